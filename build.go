@@ -43,13 +43,15 @@ var (
 	packageRoot, _        = ioutil.TempDir("", packPrefix)
 )
 
-const minGoVersion = 1.8
+// http.Protocols used by otlp output is of go 1.24
+const minGoVersion = "1.24"
 
 func main() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(0)
 
 	ensureGoPath()
+	ensureGoVersion()
 
 	flag.StringVar(&goarch, "goarch", runtime.GOARCH, "GOARCH")
 	flag.StringVar(&goos, "goos", runtime.GOOS, "GOOS")
@@ -294,6 +296,29 @@ func ensureGoPath() {
 	}
 }
 
+// ensureGoVersion exit if go running the build is older than minGoVersion,
+// versions are compared by major and minor, like go1.24.3
+func ensureGoVersion() {
+	re := regexp.MustCompile(`^go(\d+)\.(\d+)`)
+	have := re.FindStringSubmatch(runtime.Version())
+	if have == nil {
+		// devel builds have no version
+		log.Printf("Unknown go version %s, go %s or later is required\n", runtime.Version(), minGoVersion)
+		return
+	}
+	want := re.FindStringSubmatch("go" + minGoVersion)
+	for i := 1; i <= 2; i++ {
+		h, _ := strconv.Atoi(have[i])
+		w, _ := strconv.Atoi(want[i])
+		if h > w {
+			return
+		}
+		if h < w {
+			log.Fatalf("go %s or later is required, got %s", minGoVersion, runtime.Version())
+		}
+	}
+}
+
 //func ChangeWorkingDir(dir string) {
 //	os.Chdir(dir)
 //}
@@ -0,0 +1,181 @@
+/*
+exe curl http://localhost/server-status?auto get :
+
+	localhost
+	ServerVersion: Apache/2.4.41 (Ubuntu)
+	ServerMPM: event
+	Uptime: 3586
+	Total Accesses: 1279
+	Total kBytes: 3190
+	CPULoad: .00697996
+	ReqPerSec: .356665
+	BytesPerSec: 910.919
+	BytesPerReq: 2554.01
+	BusyWorkers: 1
+	IdleWorkers: 74
+	Scoreboard: __W___________________..........
+
+detail in https://httpd.apache.org/docs/2.4/mod/mod_status.html
+*/
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+type ApacheConfig struct {
+	Enable bool   `toml:"enable"`
+	Url    string `toml:"url"`
+	// seconds between collects, every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	HTTPConfig
+	TagsConfig
+}
+
+// fields of server-status reported as gauge
+var apacheGauges = map[string]string{
+	"Uptime":              "uptime",
+	"BusyWorkers":         "workers.busy",
+	"IdleWorkers":         "workers.idle",
+	"ConnsTotal":          "conns.total",
+	"ConnsAsyncWriting":   "conns.async_writing",
+	"ConnsAsyncKeepAlive": "conns.async_keep_alive",
+	"ConnsAsyncClosing":   "conns.async_closing",
+}
+
+// fields of server-status reported as float gauge
+var apacheFloatGauges = map[string]string{
+	"CPULoad":     "cpu_load",
+	"ReqPerSec":   "req_per_sec",
+	"BytesPerSec": "bytes_per_sec",
+	"BytesPerReq": "bytes_per_req",
+}
+
+// fields of server-status which only increase, reported as count and rate
+var apacheCounters = map[string]string{
+	"Total Accesses": "accesses",
+	"Total kBytes":   "kbytes",
+}
+
+// scoreboard keys
+var apacheScoreboard = map[byte]string{
+	'_': "waiting",
+	'S': "starting",
+	'R': "reading",
+	'W': "sending",
+	'K': "keepalive",
+	'D': "dns_lookup",
+	'C': "closing",
+	'L': "logging",
+	'G': "finishing",
+	'I': "idle_cleanup",
+	'.': "open_slot",
+}
+
+func NewApache(registry metrics.Registry, conf ApacheConfig) (*Apache, error) {
+	bc := metrics.NewBaseStat("apache", registry)
+	a := &Apache{
+		BaseStat:  bc,
+		StatusURL: conf.Url,
+		interval:  time.Duration(conf.IntervalSec) * time.Second,
+	}
+	var err error
+	a.client, err = newHTTPClient(conf.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Apache collect apache httpd mod_status
+type Apache struct {
+	*metrics.BaseStat
+	StatusURL string
+	client    *httpClient
+	interval  time.Duration
+	run       backgroundRun
+	totals    floatCounters
+}
+
+func (a *Apache) GetPrefix() string {
+	return a.Prefix
+}
+
+// Collect fetch status in background, a slow server don't delay other collectors
+func (a *Apache) Collect() {
+	a.run.start(a.interval, a.collect)
+}
+
+func (a *Apache) collect() {
+	addr := a.StatusURL
+	if !strings.Contains(addr, "?") {
+		// machine readable format
+		addr += "?auto"
+	}
+
+	resp, err := a.client.Get(addr)
+	if err != nil {
+		a.GaugeUpdate("up", 0)
+		a.OnErr("error_making_request",
+			fmt.Errorf("error making HTTP request to %s: %s", addr, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		a.GaugeUpdate("up", 0)
+		a.OnErr("error_response",
+			fmt.Errorf("response from %s : %s", addr, resp.Status))
+		return
+	}
+	a.GaugeUpdate("up", 1)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if k == "Scoreboard" {
+			a.collectScoreboard(v)
+		} else if name, ok := apacheGauges[k]; ok {
+			a.GaugeUpdate(name, v)
+		} else if name, ok := apacheFloatGauges[k]; ok {
+			// values like '.356665'
+			if strings.HasPrefix(v, ".") {
+				v = "0" + v
+			}
+			a.GaugeFloat64Update(name, v)
+		} else if name, ok := apacheCounters[k]; ok {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				a.totals.incTotal(a.BaseStat, name, n)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		a.OnErr("error_read_body", err)
+	}
+}
+
+func (a *Apache) collectScoreboard(board string) {
+	counts := make(map[string]int64, len(apacheScoreboard))
+	for _, name := range apacheScoreboard {
+		counts[name] = 0
+	}
+	for i := 0; i < len(board); i++ {
+		if name, ok := apacheScoreboard[board[i]]; ok {
+			counts[name]++
+		}
+	}
+	for name, v := range counts {
+		a.GaugeUpdate("scoreboard."+name, v)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+check expiry of x509 certificates in files and served by tls endpoints, like:
+
+	$ openssl x509 -noout -enddate -subject -issuer -in /etc/nginx/ssl/example.pem
+	$ openssl s_client -connect 10.0.0.1:443 -servername example.com -showcerts
+
+files may be pem with more than one certificate, like fullchain.pem, or der.
+every certificate of a file or endpoint is reported by its position, 0 is the leaf,
+the first one of a file, with subject and issuer common names (or organizations) as tags.
+chain_valid is 1 if the leaf is verified by the others and the system roots (or tls_ca),
+for endpoints the host name is verified too.
+
+metric key like:
+
+	cert.file.etc_nginx_ssl_example_pem.expiry_seconds
+	cert.file.etc_nginx_ssl_example_pem.0.expiry_seconds.issuer=r3,subject=example_com
+	cert.endpoint.example_com.chain_valid
+*/
+package collector
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// seconds between checks if not set
+const DefaultCertIntervalSec = 300
+
+type CertConfig struct {
+	Enable bool `toml:"enable"`
+	// glob patterns of certificate files, like /etc/nginx/ssl/*.pem
+	Files     []string       `toml:"files"`
+	Endpoints []CertEndpoint `toml:"endpoint"`
+	// pem file of roots to verify chains, system roots if empty
+	TLSCA      string `toml:"tls_ca"`
+	TimeoutSec int    `toml:"timeout_sec"`
+	// seconds between checks, certificates don't change often
+	IntervalSec int `toml:"interval_sec"`
+	TagsConfig
+}
+
+// CertEndpoint is a tls server
+type CertEndpoint struct {
+	// metric key segment, server_name or address if empty
+	Name    string `toml:"name"`
+	Address string `toml:"address"`
+	// sni and host name verified, host of address if empty
+	ServerName string `toml:"server_name"`
+}
+
+func NewCert(registry metrics.Registry, conf CertConfig) *Cert {
+	bc := metrics.NewBaseStat("cert", registry)
+	c := &Cert{
+		BaseStat: bc,
+		Conf:     conf,
+	}
+	if conf.TLSCA != "" {
+		data, err := ioutil.ReadFile(conf.TLSCA)
+		if err != nil {
+			c.OnErr("error_config", fmt.Errorf("error read %s: %s", conf.TLSCA, err))
+		} else {
+			c.roots = x509.NewCertPool()
+			if !c.roots.AppendCertsFromPEM(data) {
+				c.OnErr("error_config", fmt.Errorf("no certificate found in %s", conf.TLSCA))
+			}
+		}
+	}
+	if c.Conf.TimeoutSec <= 0 {
+		c.Conf.TimeoutSec = RequestMadeTimeOutSec
+	}
+	if c.Conf.IntervalSec <= 0 {
+		c.Conf.IntervalSec = DefaultCertIntervalSec
+	}
+	return c
+}
+
+// Cert check expiry of certificates
+type Cert struct {
+	*metrics.BaseStat
+	Conf CertConfig
+	// nil for system roots
+	roots *x509.CertPool
+	run   backgroundRun
+}
+
+func (c *Cert) GetPrefix() string {
+	return c.Prefix
+}
+
+// Collect check certificates every interval in background, as endpoints may be slow
+func (c *Cert) Collect() {
+	c.run.start(time.Duration(c.Conf.IntervalSec)*time.Second, c.checkAll)
+}
+
+func (c *Cert) checkAll() {
+	for _, pattern := range c.Conf.Files {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			c.OnErr("error_config", fmt.Errorf("bad pattern %s: %s", pattern, err))
+			continue
+		}
+		for _, f := range files {
+			c.collectFile(f)
+		}
+	}
+	for _, e := range c.Conf.Endpoints {
+		c.collectEndpoint(e)
+	}
+}
+
+func (c *Cert) collectFile(path string) {
+	name := "file." + util.SanitizeKey(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		c.OnErr("error_read_file", fmt.Errorf("error read %s: %s", path, err))
+		return
+	}
+	certs, err := parseCerts(data)
+	if err != nil {
+		c.OnErr("error_parse_cert", fmt.Errorf("error parse %s: %s", path, err))
+		return
+	}
+	if len(certs) == 0 {
+		// private keys and so on
+		return
+	}
+	c.report(name, certs, x509.VerifyOptions{})
+}
+
+func (c *Cert) collectEndpoint(e CertEndpoint) {
+	serverName := e.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(e.Address)
+		if err != nil {
+			c.OnErr("error_config", fmt.Errorf("bad address %s: %s", e.Address, err))
+			return
+		}
+		serverName = host
+	}
+	name := e.Name
+	if name == "" {
+		name = serverName
+	}
+	name = "endpoint." + util.SanitizeKey(name)
+
+	dialer := &net.Dialer{Timeout: time.Duration(c.Conf.TimeoutSec) * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", e.Address, &tls.Config{
+		ServerName: serverName,
+		// verified by report, so expired and untrusted certificates are reported too
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		c.GaugeUpdate(name+".up", 0)
+		c.OnErr("error_connect", fmt.Errorf("error connect %s: %s", e.Address, err))
+		return
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	c.GaugeUpdate(name+".up", 1)
+	if len(certs) == 0 {
+		return
+	}
+	c.report(name, certs, x509.VerifyOptions{DNSName: serverName})
+}
+
+// report certs, the first one is the leaf and others are intermediates
+func (c *Cert) report(name string, certs []*x509.Certificate, opts x509.VerifyOptions) {
+	now := time.Now()
+	c.GaugeUpdate(name+".certs", len(certs))
+	c.GaugeFloat64Update(name+".expiry_seconds", certs[0].NotAfter.Sub(now).Seconds())
+
+	for i, cert := range certs {
+		tags := map[string]string{
+			"subject": certName(cert.Subject),
+			"issuer":  certName(cert.Issuer),
+		}
+		k := name + "." + strconv.Itoa(i) + "."
+		c.GaugeFloat64Update(metrics.MakeMetric(k+"expiry_seconds", tags), cert.NotAfter.Sub(now).Seconds())
+		if bits := certKeyBits(cert); bits > 0 {
+			c.GaugeUpdate(metrics.MakeMetric(k+"key_bits", tags), bits)
+		}
+	}
+
+	opts.Roots = c.roots
+	opts.CurrentTime = now
+	opts.Intermediates = x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	c.GaugeUpdate(name+".chain_valid", boolToInt(err == nil))
+}
+
+// parseCerts parse pem blocks of certificates, or der
+func parseCerts(data []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		return x509.ParseCertificates(data)
+	}
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// certName return common name, or organization if no common name
+func certName(n pkix.Name) string {
+	name := util.SanitizeKey(n.CommonName)
+	if name == "" && len(n.Organization) > 0 {
+		name = util.SanitizeKey(n.Organization[0])
+	}
+	if name == "" {
+		name = "unknown"
+	}
+	return name
+}
+
+func certKeyBits(cert *x509.Certificate) int {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
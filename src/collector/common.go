@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// 多个收集器共用的一些小函数
+
+// TagsConfig is embedded in config of collectors, tags are merged into names of
+// all metrics of the collector, and win over global tags of [tags], like:
+//
+//	[collector.nginx]
+//	tags = { role = "lb" }
+type TagsConfig struct {
+	Tags map[string]string `toml:"tags"`
+}
+
+func firstOf(m map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// toFloat64 convert numbers decoded from bson, sql or json to float64
+func toFloat64(i interface{}) (float64, bool) {
+	switch v := i.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case []byte:
+		f, err := strconv.ParseFloat(string(v), 64)
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// floatCounters turn totals of counters into increments of Counter. CounterIncTotal
+// truncates floats to int64, so fraction of each increment is carried to the next.
+// CounterIncTotal also keeps last totals in a map shared by all collectors, not safe
+// for collectors run in background, they use floatCounters of their own.
+type floatCounters struct {
+	mu    sync.Mutex
+	last  map[string]float64
+	carry map[string]float64
+}
+
+// incTotal count the increment of total since last call, the first total is kept only
+func (f *floatCounters) incTotal(s *metrics.BaseStat, key string, total float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last == nil {
+		f.last = make(map[string]float64)
+		f.carry = make(map[string]float64)
+	}
+	last, ok := f.last[key]
+	f.last[key] = total
+	if !ok {
+		return
+	}
+	delta := total - last
+	if delta < 0 {
+		// counter reset, all of total is counted since then
+		delta = total
+	}
+	delta += f.carry[key]
+	n := math.Floor(delta)
+	f.carry[key] = delta - n
+	s.CounterInc(key, int64(n))
+}
+
+// backgroundRun run a collect at most once per interval, in background so a slow one
+// don't delay other collectors, a run is skipped if last run not finished.
+type backgroundRun struct {
+	sync.Mutex
+	running bool
+	lastRun time.Time
+}
+
+// start fn in background if it's due
+func (r *backgroundRun) start(interval time.Duration, fn func()) {
+	now := time.Now()
+	r.Lock()
+	due := !r.running && now.Sub(r.lastRun) >= interval
+	if due {
+		r.running = true
+		r.lastRun = now
+	}
+	r.Unlock()
+	if !due {
+		return
+	}
+	go func() {
+		defer func() {
+			r.Lock()
+			r.running = false
+			r.Unlock()
+		}()
+		fn()
+	}()
+}
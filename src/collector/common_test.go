@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+func TestFloatCounters(t *testing.T) {
+	tests := []struct {
+		name   string
+		totals []float64
+		want   int64
+	}{
+		{"first total kept only", []float64{10}, 0},
+		{"integers", []float64{10, 12, 15}, 5},
+		// 0.4 + 0.4 + 0.4, the fractions are carried
+		{"fractions", []float64{1.2, 1.6, 2.0, 2.4}, 1},
+		{"fractions summed", []float64{0, 0.5, 1.0, 1.5, 2.0}, 2},
+		// reset to 3 counts 3 since the reset
+		{"reset", []float64{100, 105, 3}, 8},
+	}
+	for _, tt := range tests {
+		r := metrics.NewRegistry()
+		s := metrics.NewBaseStat("test", r)
+		var f floatCounters
+		for _, v := range tt.totals {
+			f.incTotal(s, "c", v)
+		}
+		var got int64
+		if c, ok := r.Get("test.c").(metrics.Counter); ok {
+			got = c.Count()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
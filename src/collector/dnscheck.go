@@ -0,0 +1,331 @@
+/*
+check dns resolution by a resolver, like:
+
+	$ dig @8.8.8.8 example.com A +short
+	93.184.216.34
+
+record types: A, AAAA, CNAME, MX, NS, TXT, SRV and PTR (domain is an ip),
+the query is sent to the server as is, no /etc/hosts, search domains or cache,
+answers are records of the type in the answer section, compared with expected
+case-insensitively, trailing dots are ignored.
+
+metric key like: dns_check.example_com_a.latency_ms
+*/
+package collector
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// seconds between checks if not set
+	DefaultDnsCheckIntervalSec = 10
+
+	// max udp payload announced by edns0
+	dnsUDPSize = 4096
+)
+
+var dnsRecordTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"TXT":   dnsmessage.TypeTXT,
+	"SRV":   dnsmessage.TypeSRV,
+	"PTR":   dnsmessage.TypePTR,
+}
+
+type DnsCheckConfig struct {
+	Enable bool             `toml:"enable"`
+	Checks []DnsCheckTarget `toml:"check"`
+	// seconds between checks, not every collect as a query may take seconds
+	IntervalSec int `toml:"interval_sec"`
+	TagsConfig
+}
+
+// DnsCheckTarget is one query
+type DnsCheckTarget struct {
+	// first segment of metric key, domain and record type if empty
+	Name string `toml:"name"`
+	// ip:port of resolver, first nameserver of /etc/resolv.conf if empty
+	Server string `toml:"server"`
+	// udp or tcp, default udp, a truncated answer over udp is queried again over tcp
+	Network    string `toml:"network"`
+	Domain     string `toml:"domain"`
+	RecordType string `toml:"record_type"`
+	// one of answers must be it if set
+	Expected   string `toml:"expected"`
+	TimeoutSec int    `toml:"timeout_sec"`
+}
+
+type dnsCheckTarget struct {
+	DnsCheckTarget
+	qtype dnsmessage.Type
+}
+
+func NewDnsCheck(registry metrics.Registry, conf DnsCheckConfig) *DnsCheck {
+	bc := metrics.NewBaseStat("dns_check", registry)
+	d := &DnsCheck{
+		BaseStat: bc,
+		checks:   make([]*dnsCheckTarget, 0, len(conf.Checks)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	if conf.IntervalSec <= 0 {
+		d.interval = DefaultDnsCheckIntervalSec * time.Second
+	}
+	for _, c := range conf.Checks {
+		c.RecordType = strings.ToUpper(c.RecordType)
+		if c.RecordType == "" {
+			c.RecordType = "A"
+		}
+		qtype, ok := dnsRecordTypes[c.RecordType]
+		if !ok {
+			d.OnErr("error_config", fmt.Errorf("unknown record type %s of %s", c.RecordType, c.Domain))
+			continue
+		}
+		if c.Network == "" {
+			c.Network = "udp"
+		}
+		if c.Network != "udp" && c.Network != "tcp" {
+			d.OnErr("error_config", fmt.Errorf("unknown network %s of %s", c.Network, c.Domain))
+			continue
+		}
+		if c.Name == "" {
+			c.Name = c.Domain + "_" + c.RecordType
+		}
+		c.Name = util.SanitizeKey(c.Name)
+		if c.TimeoutSec <= 0 {
+			c.TimeoutSec = RequestMadeTimeOutSec
+		}
+		if c.Server == "" {
+			c.Server = systemNameserver()
+		}
+		if _, _, err := net.SplitHostPort(c.Server); err != nil {
+			c.Server = net.JoinHostPort(c.Server, "53")
+		}
+		d.checks = append(d.checks, &dnsCheckTarget{DnsCheckTarget: c, qtype: qtype})
+	}
+	return d
+}
+
+// systemNameserver return the first nameserver of /etc/resolv.conf, or the local one
+func systemNameserver() string {
+	data, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			f := strings.Fields(line)
+			if len(f) >= 2 && f[0] == "nameserver" {
+				return f[1]
+			}
+		}
+	}
+	return "127.0.0.1"
+}
+
+// DnsCheck check one or more dns queries
+type DnsCheck struct {
+	*metrics.BaseStat
+	checks   []*dnsCheckTarget
+	interval time.Duration
+	run      backgroundRun
+}
+
+func (d *DnsCheck) GetPrefix() string {
+	return d.Prefix
+}
+
+// Collect start queries every interval in background, so a server not answering
+// don't delay other collectors until timeout.
+func (d *DnsCheck) Collect() {
+	d.run.start(d.interval, d.checkAll)
+}
+
+// checkAll run queries concurrently
+func (d *DnsCheck) checkAll() {
+	var wg sync.WaitGroup
+	for _, c := range d.checks {
+		wg.Add(1)
+		go func(c *dnsCheckTarget) {
+			defer wg.Done()
+			d.check(c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (d *DnsCheck) check(c *dnsCheckTarget) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.TimeoutSec)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	answers, err := c.lookup(ctx)
+	if err != nil {
+		d.GaugeUpdate(c.Name+".up", 0)
+		d.OnErr("error_lookup", fmt.Errorf("error lookup %s %s: %s", c.RecordType, c.Domain, err))
+		return
+	}
+	d.GaugeFloat64Update(c.Name+".latency_ms", durationMs(time.Since(start)))
+	d.GaugeUpdate(c.Name+".answers", len(answers))
+
+	up := len(answers) > 0
+	if c.Expected != "" {
+		match := false
+		expected := strings.TrimSuffix(strings.ToLower(c.Expected), ".")
+		for _, a := range answers {
+			if strings.TrimSuffix(strings.ToLower(a), ".") == expected {
+				match = true
+				break
+			}
+		}
+		d.GaugeUpdate(c.Name+".expected_match", boolToInt(match))
+		up = up && match
+	}
+	d.GaugeUpdate(c.Name+".up", boolToInt(up))
+}
+
+// lookup query the server, return records of the type in answer section as strings
+func (c *dnsCheckTarget) lookup(ctx context.Context) ([]string, error) {
+	domain := c.Domain
+	if c.qtype == dnsmessage.TypePTR {
+		if ip := net.ParseIP(domain); ip != nil {
+			domain = reverseName(ip)
+		}
+	}
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+	name, err := dnsmessage.NewName(domain)
+	if err != nil {
+		return nil, err
+	}
+	query := &dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: c.qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	query.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+
+	resp, err := dnsExchange(ctx, c.Network, c.Server, query)
+	if err == nil && resp.Truncated && c.Network == "udp" {
+		resp, err = dnsExchange(ctx, "tcp", c.Server, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("answer %s", resp.RCode)
+	}
+
+	answers := make([]string, 0, len(resp.Answers))
+	for _, rr := range resp.Answers {
+		// CNAMEs followed by the server to the answers are skipped
+		if rr.Header.Type != c.qtype {
+			continue
+		}
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, net.IP(b.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, net.IP(b.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			answers = append(answers, b.CNAME.String())
+		case *dnsmessage.MXResource:
+			answers = append(answers, b.MX.String())
+		case *dnsmessage.NSResource:
+			answers = append(answers, b.NS.String())
+		case *dnsmessage.TXTResource:
+			answers = append(answers, strings.Join(b.TXT, ""))
+		case *dnsmessage.SRVResource:
+			answers = append(answers, fmt.Sprintf("%s:%d", b.Target.String(), b.Port))
+		case *dnsmessage.PTRResource:
+			answers = append(answers, b.PTR.String())
+		}
+	}
+	return answers, nil
+}
+
+// dnsExchange send query to server and read the answer, messages over tcp are
+// prefixed by 2 bytes of length
+func dnsExchange(ctx context.Context, network, server string, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		prefixed := make([]byte, 2, 2+len(packed))
+		binary.BigEndian.PutUint16(prefixed, uint16(len(packed)))
+		if _, err := conn.Write(append(prefixed, packed...)); err != nil {
+			return nil, err
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, dnsUDPSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	resp := &dnsmessage.Message{}
+	if err := resp.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if !resp.Response || resp.ID != query.ID {
+		return nil, fmt.Errorf("answer id %d not of query %d", resp.ID, query.ID)
+	}
+	return resp, nil
+}
+
+// reverseName return the in-addr.arpa or ip6.arpa name of ip for PTR queries
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	}
+	const hex = "0123456789abcdef"
+	b := make([]byte, 0, 72)
+	for i := len(ip) - 1; i >= 0; i-- {
+		b = append(b, hex[ip[i]&0xf], '.', hex[ip[i]>>4], '.')
+	}
+	return string(b) + "ip6.arpa."
+}
@@ -0,0 +1,392 @@
+/*
+run external commands and parse their output as metrics, each command run on its own interval.
+formats of output:
+
+graphite, plaintext lines of '<path> <value> [timestamp]':
+
+	backup.size 1048576 1700000000
+
+statsd, lines of '<key>:<value>|<c|g|ms>':
+
+	backup.files:120|c
+	backup.duration:3500|ms
+
+json, numbers and booleans of an object, nested objects are flattened:
+
+	{"backup": {"size": 1048576, "ok": true}}
+
+nagios, exit code is reported as status, 0 OK, 1 WARNING, 2 CRITICAL, 3 UNKNOWN,
+perfdata after '|' is reported with warn, crit, min and max:
+
+	DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968 'inodes used'=12%;80;90
+
+detail in https://nagios-plugins.org/doc/guidelines.html#AEN200
+
+metric key like: exec.check_disk.status, exec.check_disk.perf._.value
+*/
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+const (
+	// time limit seconds of a command if not set
+	DefaultExecTimeoutSec = 10
+
+	// output is truncated in error messages
+	execMaxErrorOutput = 256
+)
+
+type ExecConfig struct {
+	Enable   bool          `toml:"enable"`
+	Commands []ExecCommand `toml:"command"`
+	TagsConfig
+}
+
+// ExecCommand is one command to run
+type ExecCommand struct {
+	// first segment of metric key, base name of command if empty
+	Name string `toml:"name"`
+	// command and arguments, not run by shell
+	Command []string `toml:"command"`
+	// graphite, statsd, json or nagios
+	Format string `toml:"format"`
+	// run every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	TimeoutSec  int `toml:"timeout_sec"`
+}
+
+type execCommand struct {
+	ExecCommand
+	run backgroundRun
+}
+
+func NewExec(registry metrics.Registry, conf ExecConfig) *Exec {
+	bc := metrics.NewBaseStat("exec", registry)
+	e := &Exec{
+		BaseStat: bc,
+		commands: make([]*execCommand, 0, len(conf.Commands)),
+	}
+	for _, c := range conf.Commands {
+		if len(c.Command) == 0 {
+			e.OnErr("error_config", fmt.Errorf("command of %s is empty", c.Name))
+			continue
+		}
+		switch c.Format {
+		case "graphite", "statsd", "json", "nagios":
+		case "":
+			c.Format = "graphite"
+		default:
+			e.OnErr("error_config", fmt.Errorf("unknown format %s of %s", c.Format, c.Name))
+			continue
+		}
+		if c.Name == "" {
+			parts := strings.Split(c.Command[0], "/")
+			c.Name = parts[len(parts)-1]
+		}
+		c.Name = util.SanitizeKey(c.Name)
+		if c.TimeoutSec <= 0 {
+			c.TimeoutSec = DefaultExecTimeoutSec
+		}
+		e.commands = append(e.commands, &execCommand{ExecCommand: c})
+	}
+	return e
+}
+
+// Exec run external commands and report their output
+type Exec struct {
+	*metrics.BaseStat
+	commands []*execCommand
+	// commands run in background, CounterIncTotal keeps totals in a map not safe for it
+	totals floatCounters
+}
+
+func (e *Exec) GetPrefix() string {
+	return e.Prefix
+}
+
+// Collect start commands which are due, in background so a slow command
+// don't delay other collectors, a command is skipped if last run not finished.
+func (e *Exec) Collect() {
+	for _, c := range e.commands {
+		c := c
+		c.run.start(time.Duration(c.IntervalSec)*time.Second, func() { e.run(c) })
+	}
+}
+
+func (e *Exec) run(c *execCommand) {
+	start := time.Now()
+	out, code, err := execRunTimeout(time.Duration(c.TimeoutSec)*time.Second, c.Command[0], c.Command[1:]...)
+	e.GaugeUpdate(c.Name+".duration_ms", int64(time.Since(start)/time.Millisecond))
+	if err != nil {
+		e.GaugeUpdate(c.Name+".up", 0)
+		e.OnErr("error_exec", fmt.Errorf("error exec %s: %s", c.Name, err))
+		return
+	}
+	e.GaugeUpdate(c.Name+".up", 1)
+	e.GaugeUpdate(c.Name+".exit_code", code)
+
+	if c.Format == "nagios" {
+		e.parseNagios(c.Name, out, code)
+		return
+	}
+	if code != 0 {
+		e.OnErr("error_exit", fmt.Errorf("%s exit %d: %s", c.Name, code, truncate(out, execMaxErrorOutput)))
+		return
+	}
+	switch c.Format {
+	case "graphite":
+		err = e.parseGraphite(c.Name, out)
+	case "statsd":
+		err = e.parseStatsd(c.Name, out)
+	case "json":
+		err = e.parseJSON(c.Name, out)
+	}
+	if err != nil {
+		e.OnErr("error_parse", fmt.Errorf("error parse output of %s: %s", c.Name, err))
+	}
+}
+
+func (e *Exec) parseGraphite(name string, out []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("bad line: %s", scanner.Text())
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return fmt.Errorf("bad value: %s", scanner.Text())
+		}
+		e.GaugeFloat64Update(name+"."+fields[0], v)
+	}
+	return scanner.Err()
+}
+
+func (e *Exec) parseStatsd(name string, out []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// <key>:<value>|<type>[|@<rate>]
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("bad line: %s", line)
+		}
+		fields := strings.Split(kv[1], "|")
+		if len(fields) < 2 {
+			return fmt.Errorf("bad line: %s", line)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return fmt.Errorf("bad value: %s", line)
+		}
+		key := name + "." + kv[0]
+		switch fields[1] {
+		case "c":
+			e.CounterInc(key, v)
+		case "g":
+			e.GaugeFloat64Update(key, v)
+		case "ms", "h":
+			t := e.Registry.GetOrRegister(e.GetMemMetric(key), metrics.NewTimer())
+			t.(metrics.Timer).Update(time.Duration(v * float64(time.Millisecond)))
+		default:
+			return fmt.Errorf("unknown type: %s", line)
+		}
+	}
+	return scanner.Err()
+}
+
+func (e *Exec) parseJSON(name string, out []byte) error {
+	var doc map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(out))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return err
+	}
+	e.updateJSON(name, doc)
+	return nil
+}
+
+func (e *Exec) updateJSON(key string, obj map[string]interface{}) {
+	for k, v := range obj {
+		sub := key + "." + util.SanitizeKey(k)
+		if child, ok := v.(map[string]interface{}); ok {
+			e.updateJSON(sub, child)
+			continue
+		}
+		if _, ok := v.(string); ok {
+			continue
+		}
+		if n, ok := jsonNumber(v, nil); ok {
+			e.GaugeFloat64Update(sub, n)
+		}
+	}
+}
+
+// parseNagios report exit code as status and perfdata, perfdata may follow '|'
+// of the first line and of the following lines, like:
+//
+//	TEXT | perf1=1;2;3
+//	LONG TEXT LINE 2
+//	LONG TEXT LINE 3 | perf2=4 perf3=5
+func (e *Exec) parseNagios(name string, out []byte, code int) {
+	if code < 0 || code > 3 {
+		code = 3
+	}
+	e.GaugeUpdate(name+".status", code)
+
+	perfdata := make([]string, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, "|"); i >= 0 {
+			perfdata = append(perfdata, line[i+1:])
+		}
+	}
+	for _, p := range perfdata {
+		for _, perf := range splitPerfdata(p) {
+			if err := e.updatePerf(name, perf); err != nil {
+				e.OnErr("error_parse_perfdata", fmt.Errorf("error parse perfdata of %s: %s", name, err))
+			}
+		}
+	}
+}
+
+// updatePerf report one perfdata: 'label'=value[UOM];[warn];[crit];[min];[max]
+func (e *Exec) updatePerf(name, perf string) error {
+	i := strings.LastIndex(perf, "=")
+	if i <= 0 {
+		return fmt.Errorf("bad perfdata %s", perf)
+	}
+	label := util.SanitizeKey(strings.Trim(perf[:i], "'"))
+	if label == "" {
+		// labels like '/' of check_disk
+		label = "_"
+	}
+	key := name + ".perf." + label
+	fields := strings.Split(perf[i+1:], ";")
+
+	value, uom := fields[0], ""
+	if j := strings.IndexFunc(value, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E')
+	}); j >= 0 {
+		value, uom = value[:j], value[j:]
+	}
+	if value == "" || value == "U" {
+		// value can't be determined
+		return nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("bad value %s", perf)
+	}
+	scale, counter := perfUnit(uom)
+	if counter {
+		e.totals.incTotal(e.BaseStat, key, v)
+		return nil
+	}
+	e.GaugeFloat64Update(key+".value", v*scale)
+
+	for n, f := range []string{"warn", "crit", "min", "max"} {
+		if n+1 >= len(fields) {
+			break
+		}
+		// thresholds may be ranges like 10:20, only plain numbers reported
+		if t, err := strconv.ParseFloat(fields[n+1], 64); err == nil {
+			e.GaugeFloat64Update(key+"."+f, t*scale)
+		}
+	}
+	return nil
+}
+
+// perfUnit return scale to base unit, seconds and bytes, and whether it is a counter
+func perfUnit(uom string) (float64, bool) {
+	switch strings.ToUpper(uom) {
+	case "MS":
+		return 1e-3, false
+	case "US":
+		return 1e-6, false
+	case "KB":
+		return 1 << 10, false
+	case "MB":
+		return 1 << 20, false
+	case "GB":
+		return 1 << 30, false
+	case "TB":
+		return 1 << 40, false
+	case "C":
+		return 1, true
+	}
+	return 1, false
+}
+
+// splitPerfdata split perfdata by space, labels quoted by ' may contain spaces
+func splitPerfdata(s string) []string {
+	perfs := make([]string, 0)
+	var cur strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+			cur.WriteByte(c)
+		case (c == ' ' || c == '\t') && !quoted:
+			if cur.Len() > 0 {
+				perfs = append(perfs, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 {
+		perfs = append(perfs, cur.String())
+	}
+	return perfs
+}
+
+// execRunTimeout run a command like execRun, but kill it after timeout,
+// return stdout and exit code, a non-zero exit code is not an error.
+func execRunTimeout(timeout time.Duration, cmd string, args ...string) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ecmd := exec.CommandContext(ctx, cmd, args...)
+	// children of a killed script may hold the output pipe open
+	ecmd.WaitDelay = time.Second
+	out, err := ecmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, 0, fmt.Errorf("timeout after %s", timeout)
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out, exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, 0, nil
+}
+
+func truncate(s []byte, n int) string {
+	s = bytes.TrimSpace(s)
+	if len(s) > n {
+		return string(s[:n]) + "..."
+	}
+	return string(s)
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+func TestSplitPerfdata(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{}},
+		{"  ", []string{}},
+		{"time=0.5s", []string{"time=0.5s"}},
+		{" /=2643MB;5948;5958;0;5968  /var=10MB\t", []string{"/=2643MB;5948;5958;0;5968", "/var=10MB"}},
+		{"'inodes used'=12%;80;90 load=1", []string{"'inodes used'=12%;80;90", "load=1"}},
+		{"'a  b'=1", []string{"'a  b'=1"}},
+	}
+	for _, tt := range tests {
+		got := splitPerfdata(tt.s)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPerfUnit(t *testing.T) {
+	tests := []struct {
+		uom     string
+		scale   float64
+		counter bool
+	}{
+		{"", 1, false},
+		{"s", 1, false},
+		{"%", 1, false},
+		{"ms", 1e-3, false},
+		{"us", 1e-6, false},
+		{"B", 1, false},
+		{"KB", 1024, false},
+		{"MB", 1024 * 1024, false},
+		{"gb", 1024 * 1024 * 1024, false},
+		{"TB", 1024 * 1024 * 1024 * 1024, false},
+		{"c", 1, true},
+	}
+	for _, tt := range tests {
+		scale, counter := perfUnit(tt.uom)
+		if scale != tt.scale || counter != tt.counter {
+			t.Errorf("%q: got %v %v, want %v %v", tt.uom, scale, counter, tt.scale, tt.counter)
+		}
+	}
+}
+
+func TestParseNagios(t *testing.T) {
+	r := metrics.NewRegistry()
+	e := NewExec(r, ExecConfig{})
+	e.parseNagios("check", []byte("DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968 'inodes used'=12%;80;90\n"+
+		"LONG TEXT | time=250ms;@10:20;500 rx=100c up=U bad=x1\n"), 1)
+	// counters are reported by the increase from last run
+	e.parseNagios("check", []byte("OK | rx=130c\n"), 0)
+
+	tests := []struct {
+		key  string
+		want float64
+	}{
+		{"status", 0},
+		{"perf._.value", 2643 * 1024 * 1024},
+		{"perf._.warn", 5948 * 1024 * 1024},
+		{"perf._.crit", 5958 * 1024 * 1024},
+		{"perf._.min", 0},
+		{"perf._.max", 5968 * 1024 * 1024},
+		{"perf.inodes_used.value", 12},
+		{"perf.inodes_used.warn", 80},
+		{"perf.inodes_used.crit", 90},
+		{"perf.time.value", 0.25},
+		{"perf.time.crit", 0.5},
+		{"perf.rx", 30},
+	}
+	for _, tt := range tests {
+		var got float64
+		switch v := r.Get("exec.check." + tt.key).(type) {
+		case metrics.Gauge:
+			got = float64(v.Value())
+		case metrics.GaugeFloat64:
+			got = v.Value()
+		case metrics.Counter:
+			got = float64(v.Count())
+		default:
+			t.Errorf("%s: not found", tt.key)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.key, got, tt.want)
+		}
+	}
+
+	// ranges of thresholds, unknown values and bad values are not reported
+	for _, key := range []string{"perf.time.warn", "perf.inodes_used.min", "perf.up.value", "perf.bad.value"} {
+		if v := r.Get("exec.check." + key); v != nil {
+			t.Errorf("%s: got %v, want not reported", key, v)
+		}
+	}
+}
+
+func TestUpdatePerfError(t *testing.T) {
+	e := NewExec(metrics.NewRegistry(), ExecConfig{})
+	for _, perf := range []string{"load", "=1", "load=1.2.3", "load=--1"} {
+		if err := e.updatePerf("check", perf); err == nil {
+			t.Errorf("%s: want error", perf)
+		}
+	}
+}
+
+// a command is not started again until its last run is finished
+func TestExecCollect(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	r := metrics.NewRegistry()
+	e := NewExec(r, ExecConfig{Commands: []ExecCommand{{
+		Name:    "backup",
+		Command: []string{"sh", "-c", "echo run >> " + runs + "; sleep 0.3; echo size 42"},
+	}}})
+
+	waitRuns := func(want int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			b, _ := os.ReadFile(runs)
+			g, ok := r.Get("exec.backup.size").(metrics.GaugeFloat64)
+			if strings.Count(string(b), "run") == want && ok && g.Value() == 42 {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		b, _ := os.ReadFile(runs)
+		t.Fatalf("got %d runs, want %d", strings.Count(string(b), "run"), want)
+	}
+
+	e.Collect()
+	e.Collect()
+	waitRuns(1)
+	// wait the first run finished
+	time.Sleep(100 * time.Millisecond)
+	r.Unregister("exec.backup.size")
+	e.Collect()
+	waitRuns(2)
+}
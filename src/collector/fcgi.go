@@ -0,0 +1,160 @@
+package collector
+
+/*
+ a minimal fastcgi client, send one GET request and read the response
+ detail in https://fast-cgi.github.io/spec
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fcgiVersion       = 1
+	fcgiBeginRequest  = 1
+	fcgiEndRequest    = 3
+	fcgiParams        = 4
+	fcgiStdin         = 5
+	fcgiStdout        = 6
+	fcgiStderr        = 7
+	fcgiRoleResponder = 1
+	fcgiRequestId     = 1
+	fcgiMaxContent    = 65535
+)
+
+// fcgiGet request path with query on a fastcgi server like php-fpm,
+// return status code and body of the response.
+func fcgiGet(network, address, path, query string) (int, []byte, error) {
+	conn, err := net.DialTimeout(network, address, RequestMadeTimeOutSec*time.Second)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ResponseTimeoutSec * time.Second))
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"REQUEST_METHOD":    "GET",
+		"SCRIPT_NAME":       path,
+		"SCRIPT_FILENAME":   path,
+		"REQUEST_URI":       path + "?" + query,
+		"QUERY_STRING":      query,
+	}
+
+	w := bufio.NewWriter(conn)
+	begin := []byte{0, fcgiRoleResponder, 0, 0, 0, 0, 0, 0}
+	fcgiWriteRecord(w, fcgiBeginRequest, begin)
+	fcgiWriteRecord(w, fcgiParams, fcgiEncodeParams(params))
+	fcgiWriteRecord(w, fcgiParams, nil)
+	fcgiWriteRecord(w, fcgiStdin, nil)
+	if err := w.Flush(); err != nil {
+		return 0, nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	r := bufio.NewReader(conn)
+	for {
+		t, content, err := fcgiReadRecord(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if t == fcgiEndRequest {
+			break
+		}
+		switch t {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		}
+	}
+	if stdout.Len() == 0 && stderr.Len() > 0 {
+		return 0, nil, fmt.Errorf("fastcgi stderr: %s", strings.TrimSpace(stderr.String()))
+	}
+	return fcgiParseResponse(&stdout)
+}
+
+func fcgiWriteRecord(w io.Writer, t uint8, content []byte) {
+	for {
+		n := len(content)
+		if n > fcgiMaxContent {
+			n = fcgiMaxContent
+		}
+		padding := (8 - n%8) % 8
+		header := []byte{fcgiVersion, t, 0, fcgiRequestId, 0, 0, byte(padding), 0}
+		binary.BigEndian.PutUint16(header[4:6], uint16(n))
+		w.Write(header)
+		w.Write(content[:n])
+		w.Write(make([]byte, padding))
+		content = content[n:]
+		if len(content) == 0 {
+			return
+		}
+	}
+}
+
+func fcgiReadRecord(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(header[4:6]))
+	padding := int(header[6])
+	content := make([]byte, n+padding)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return header[1], content[:n], nil
+}
+
+func fcgiEncodeParams(params map[string]string) []byte {
+	var b bytes.Buffer
+	for k, v := range params {
+		fcgiEncodeLength(&b, len(k))
+		fcgiEncodeLength(&b, len(v))
+		b.WriteString(k)
+		b.WriteString(v)
+	}
+	return b.Bytes()
+}
+
+func fcgiEncodeLength(b *bytes.Buffer, n int) {
+	if n < 128 {
+		b.WriteByte(byte(n))
+		return
+	}
+	l := make([]byte, 4)
+	binary.BigEndian.PutUint32(l, uint32(n)|1<<31)
+	b.Write(l)
+}
+
+// fcgiParseResponse split cgi response to status and body, the status is
+// given by a 'Status: 404 Not Found' header, 200 if missing.
+func fcgiParseResponse(stdout *bytes.Buffer) (int, []byte, error) {
+	r := textproto.NewReader(bufio.NewReader(stdout))
+	header, err := r.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	code := http.StatusOK
+	if status := header.Get("Status"); status != "" {
+		code, err = strconv.Atoi(strings.Fields(status)[0])
+		if err != nil {
+			return 0, nil, fmt.Errorf("bad status: %s", status)
+		}
+	}
+	body, err := ioutil.ReadAll(r.R)
+	return code, body, err
+}
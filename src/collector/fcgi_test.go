@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"testing"
+)
+
+func TestFcgiRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		size    int
+		records int
+	}{
+		{0, 1},
+		{1, 1},
+		{8, 1},
+		{100, 1},
+		{fcgiMaxContent, 1},
+		{fcgiMaxContent + 1, 2},
+		{3*fcgiMaxContent + 5, 4},
+	}
+	for _, tt := range tests {
+		content := make([]byte, tt.size)
+		for i := range content {
+			content[i] = byte(i)
+		}
+		var buf bytes.Buffer
+		fcgiWriteRecord(&buf, fcgiStdout, content)
+		if buf.Len()%8 != 0 {
+			t.Errorf("size %d: records of %d bytes not padded to 8", tt.size, buf.Len())
+		}
+
+		var got []byte
+		records := 0
+		for buf.Len() > 0 {
+			typ, c, err := fcgiReadRecord(&buf)
+			if err != nil {
+				t.Fatalf("size %d: %s", tt.size, err)
+			}
+			if typ != fcgiStdout {
+				t.Errorf("size %d: got type %d", tt.size, typ)
+			}
+			got = append(got, c...)
+			records++
+		}
+		if records != tt.records {
+			t.Errorf("size %d: got %d records, want %d", tt.size, records, tt.records)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("size %d: content changed after round trip", tt.size)
+		}
+	}
+}
+
+func TestFcgiReadRecordShort(t *testing.T) {
+	var buf bytes.Buffer
+	fcgiWriteRecord(&buf, fcgiStdout, []byte("hello"))
+	for _, n := range []int{0, 4, 8, 12} {
+		if _, _, err := fcgiReadRecord(bytes.NewReader(buf.Bytes()[:n])); err == nil {
+			t.Errorf("%d bytes of record: want error", n)
+		}
+	}
+}
+
+// decodeFcgiParams is the reverse of fcgiEncodeParams
+func decodeFcgiParams(t *testing.T, b []byte) map[string]string {
+	length := func() int {
+		if b[0]>>7 == 0 {
+			n := int(b[0])
+			b = b[1:]
+			return n
+		}
+		n := int(binary.BigEndian.Uint32(b) &^ (1 << 31))
+		b = b[4:]
+		return n
+	}
+	params := make(map[string]string)
+	for len(b) > 0 {
+		kl, vl := length(), length()
+		if kl+vl > len(b) {
+			t.Fatalf("bad params, %d bytes left for %d", len(b), kl+vl)
+		}
+		params[string(b[:kl])] = string(b[kl : kl+vl])
+		b = b[kl+vl:]
+	}
+	return params
+}
+
+func TestFcgiEncodeParams(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 300))
+	tests := []map[string]string{
+		{},
+		{"QUERY_STRING": ""},
+		{"SCRIPT_NAME": "/status", "QUERY_STRING": "json&full"},
+		{"REQUEST_URI": long},
+		{long: "v"},
+		{string(bytes.Repeat([]byte("k"), 127)): string(bytes.Repeat([]byte("v"), 128))},
+	}
+	for _, params := range tests {
+		got := decodeFcgiParams(t, fcgiEncodeParams(params))
+		if len(got) != len(params) {
+			t.Errorf("got %d params, want %d", len(got), len(params))
+		}
+		for k, v := range params {
+			if got[k] != v {
+				t.Errorf("param %.10s: got %.10q, want %.10q", k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestFcgiParseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdout   string
+		wantCode int
+		wantBody string
+		wantErr  bool
+	}{
+		{"no status", "Content-Type: text/plain\r\n\r\npool: www\n", 200, "pool: www\n", false},
+		{"status", "Status: 404 Not Found\r\nContent-Type: text/html\r\n\r\nFile not found.\n", 404, "File not found.\n", false},
+		{"status only code", "Status: 503\r\n\r\n", 503, "", false},
+		{"bad status", "Status: OK\r\n\r\n", 0, "", true},
+		{"empty", "", 200, "", false},
+	}
+	for _, tt := range tests {
+		code, body, err := fcgiParseResponse(bytes.NewBufferString(tt.stdout))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if code != tt.wantCode || string(body) != tt.wantBody {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, code, body, tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+// fcgiGet against the fastcgi server of net/http/fcgi
+func TestFcgiGet(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go fcgi.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("query: " + r.URL.RawQuery))
+	}))
+
+	tests := []struct {
+		path     string
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"/status", "json&full", 200, "query: json&full"},
+		{"/status", "", 200, "query: "},
+		{"/missing", "", 404, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		code, body, err := fcgiGet("tcp", ln.Addr().String(), tt.path, tt.query)
+		if err != nil {
+			t.Errorf("%s: %s", tt.path, err)
+			continue
+		}
+		if code != tt.wantCode || string(body) != tt.wantBody {
+			t.Errorf("%s?%s: got %d %q, want %d %q", tt.path, tt.query, code, body, tt.wantCode, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+watch size and modification time of files, like:
+
+	$ find /data/backup -name "*.sql.gz" -maxdepth 2 -printf "%s %T@ %p\n"
+
+a path may be a file, a directory or a glob pattern, files of directories are
+walked, only direct children unless recursive. files found by a path are reported
+together, so backups named by date are still one metric:
+
+	files                  number of files
+	size                   total bytes
+	newest_age_seconds     since the latest modification, backups stop appearing if it grows
+	oldest_age_seconds     since the earliest modification, a spool backs up if it grows
+	growth_bytes_per_sec   change of size since last collect
+
+ages are not reported when no file is found, files drops to 0 instead.
+
+metric key like: filestat.backup.newest_age_seconds
+*/
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// seconds between walks if not set
+const DefaultFilestatIntervalSec = 60
+
+type FilestatConfig struct {
+	Enable bool           `toml:"enable"`
+	Paths  []FilestatPath `toml:"path"`
+	// seconds between walks, a big directory tree takes a while to walk
+	IntervalSec int `toml:"interval_sec"`
+	TagsConfig
+}
+
+// FilestatPath is a file, directory or glob pattern
+type FilestatPath struct {
+	// first segment of metric key, path if empty
+	Name string `toml:"name"`
+	Path string `toml:"path"`
+	// walk sub directories
+	Recursive bool `toml:"recursive"`
+	// depth of sub directories walked in recursive mode, 0 for unlimited
+	MaxDepth int `toml:"max_depth"`
+	// glob patterns of file names like "*.log", all files if empty,
+	// exclude is applied after include
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+}
+
+type filestatPath struct {
+	FilestatPath
+	lastSize int64
+	lastTime time.Time
+}
+
+// filestatSummary of files found by a path
+type filestatSummary struct {
+	files  int64
+	size   int64
+	newest time.Time
+	oldest time.Time
+}
+
+func NewFilestat(registry metrics.Registry, conf FilestatConfig) *Filestat {
+	bc := metrics.NewBaseStat("filestat", registry)
+	f := &Filestat{
+		BaseStat: bc,
+		paths:    make([]*filestatPath, 0, len(conf.Paths)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	if conf.IntervalSec <= 0 {
+		f.interval = DefaultFilestatIntervalSec * time.Second
+	}
+	for _, p := range conf.Paths {
+		if p.Name == "" {
+			p.Name = p.Path
+		}
+		p.Name = util.SanitizeKey(p.Name)
+		f.paths = append(f.paths, &filestatPath{FilestatPath: p})
+	}
+	return f
+}
+
+// Filestat watch files and directories
+type Filestat struct {
+	*metrics.BaseStat
+	paths    []*filestatPath
+	interval time.Duration
+	run      backgroundRun
+}
+
+func (f *Filestat) GetPrefix() string {
+	return f.Prefix
+}
+
+// Collect walk paths every interval in background
+func (f *Filestat) Collect() {
+	f.run.start(f.interval, f.collectAll)
+}
+
+func (f *Filestat) collectAll() {
+	for _, p := range f.paths {
+		f.collectPath(p)
+	}
+}
+
+func (f *Filestat) collectPath(p *filestatPath) {
+	matches, err := filepath.Glob(p.Path)
+	if err != nil {
+		f.OnErr("error_config", fmt.Errorf("bad pattern %s: %s", p.Path, err))
+		return
+	}
+
+	now := time.Now()
+	s := &filestatSummary{}
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			if p.allowed(info.Name()) {
+				s.add(info)
+			}
+			continue
+		}
+		if err := p.walk(m, s); err != nil {
+			f.OnErr("error_walk", fmt.Errorf("error walk %s: %s", m, err))
+		}
+	}
+
+	f.GaugeUpdate(p.Name+".files", s.files)
+	f.GaugeUpdate(p.Name+".size", s.size)
+	if s.files > 0 {
+		f.GaugeFloat64Update(p.Name+".newest_age_seconds", now.Sub(s.newest).Seconds())
+		f.GaugeFloat64Update(p.Name+".oldest_age_seconds", now.Sub(s.oldest).Seconds())
+	} else {
+		// ages of files gone would look fresh forever
+		f.Registry.Unregister(f.GetMemMetric(p.Name + ".newest_age_seconds"))
+		f.Registry.Unregister(f.GetMemMetric(p.Name + ".oldest_age_seconds"))
+	}
+	if !p.lastTime.IsZero() {
+		elapsed := now.Sub(p.lastTime).Seconds()
+		if elapsed > 0 {
+			f.GaugeFloat64Update(p.Name+".growth_bytes_per_sec", float64(s.size-p.lastSize)/elapsed)
+		}
+	}
+	p.lastSize, p.lastTime = s.size, now
+}
+
+// walk files of dir, sub directories only if recursive and not too deep
+func (p *filestatPath) walk(dir string, s *filestatSummary) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// permission denied and files removed while walking
+			return nil
+		}
+		if info.IsDir() {
+			if path == dir {
+				return nil
+			}
+			depth := strings.Count(strings.TrimPrefix(path, dir), string(filepath.Separator))
+			if !p.Recursive || (p.MaxDepth > 0 && depth > p.MaxDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && p.allowed(info.Name()) {
+			s.add(info)
+		}
+		return nil
+	})
+}
+
+// allowed test file name by include and exclude patterns
+func (p *filestatPath) allowed(name string) bool {
+	if len(p.Include) > 0 && !filestatMatch(p.Include, name) {
+		return false
+	}
+	return !filestatMatch(p.Exclude, name)
+}
+
+func filestatMatch(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *filestatSummary) add(info os.FileInfo) {
+	s.files++
+	s.size += info.Size()
+	t := info.ModTime()
+	if s.newest.IsZero() || t.After(s.newest) {
+		s.newest = t
+	}
+	if s.oldest.IsZero() || t.Before(s.oldest) {
+		s.oldest = t
+	}
+}
@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// ages are dropped when the last file is gone, instead of kept as they were
+func TestFilestatEmptied(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "db.sql.gz")
+	if err := os.WriteFile(file, []byte("backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	f := NewFilestat(r, FilestatConfig{Paths: []FilestatPath{{Name: "backup", Path: dir}}})
+
+	gauge := func(key string) (int64, bool) {
+		g, ok := r.Get("filestat.backup." + key).(metrics.Gauge)
+		if !ok {
+			return 0, false
+		}
+		return g.Value(), true
+	}
+
+	f.collectAll()
+	if n, _ := gauge("files"); n != 1 {
+		t.Errorf("got files %d, want 1", n)
+	}
+	for _, key := range []string{"newest_age_seconds", "oldest_age_seconds"} {
+		if r.Get("filestat.backup."+key) == nil {
+			t.Errorf("%s not reported", key)
+		}
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	f.collectAll()
+	if n, ok := gauge("files"); !ok || n != 0 {
+		t.Errorf("got files %d, want 0", n)
+	}
+	for _, key := range []string{"newest_age_seconds", "oldest_age_seconds"} {
+		if v := r.Get("filestat.backup." + key); v != nil {
+			t.Errorf("%s: got %v, want not reported", key, v)
+		}
+	}
+}
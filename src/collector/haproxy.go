@@ -0,0 +1,247 @@
+/*
+collect haproxy stats in csv by stats socket or http:
+
+	$ echo "show stat" | socat stdio /var/run/haproxy.sock
+	$ curl http://localhost:8404/stats;csv
+
+	# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,...
+	http-in,FRONTEND,,,2,12,2000,1034,130472,5032221,0,0,3,,,,,OPEN,...
+	web,web-01,0,0,1,5,,517,65236,2516110,,0,,0,0,0,0,UP,1,1,0,0,0,3712,0,,...
+	web,BACKEND,0,0,1,6,200,1031,130472,5032221,0,0,,0,0,0,0,UP,2,2,0,,0,3712,0,,...
+
+detail in https://docs.haproxy.org/2.8/management.html#9.1
+
+proxy and server names are sanitized to one segment of metric key, like:
+haproxy.var_run_haproxy_sock.server.web.web-01.scur
+*/
+package collector
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+type HaproxyConfig struct {
+	Enable bool `toml:"enable"`
+	// unix:///var/run/haproxy.sock for stats socket, or url of stats page
+	// like http://localhost:8404/stats, ';csv' is appended if missing
+	Servers []string `toml:"servers"`
+	// seconds between collects, every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	HTTPConfig
+	TagsConfig
+}
+
+// columns reported as they are
+var haproxyGauges = []string{
+	"qcur", "qmax", "scur", "smax", "slim", "weight", "act", "bck",
+	"lastchg", "rate", "req_rate", "check_code", "check_duration",
+	"qtime", "ctime", "rtime", "ttime",
+}
+
+// columns which only increase, reported as count and rate
+var haproxyCounters = []string{
+	"stot", "bin", "bout", "dreq", "dresp", "ereq", "econ", "eresp",
+	"wretr", "wredis", "chkfail", "chkdown", "downtime", "lbtot",
+	"hrsp_1xx", "hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx", "hrsp_other",
+	"req_tot", "cli_abrt", "srv_abrt",
+}
+
+// value of column 'type'
+var haproxyTypes = map[string]string{
+	"0": "frontend",
+	"1": "backend",
+	"2": "server",
+	"3": "listener",
+}
+
+func NewHaproxy(registry metrics.Registry, conf HaproxyConfig) (*Haproxy, error) {
+	bc := metrics.NewBaseStat("haproxy", registry)
+	h := &Haproxy{
+		BaseStat: bc,
+		servers:  make([]*haproxyServer, 0, len(conf.Servers)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	var err error
+	h.client, err = newHTTPClient(conf.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range conf.Servers {
+		addr = strings.TrimSpace(addr)
+		s := &haproxyServer{address: addr}
+		if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+			if !strings.HasSuffix(addr, ";csv") {
+				s.address = addr + ";csv"
+			}
+			s.name = util.SanitizeKey(strings.SplitN(addr, "://", 2)[1])
+		} else {
+			s.address = strings.TrimPrefix(addr, "unix://")
+			s.socket = true
+			s.name = util.SanitizeKey(s.address)
+		}
+		h.servers = append(h.servers, s)
+	}
+	return h, nil
+}
+
+// Haproxy collect stats of one or more haproxy
+type Haproxy struct {
+	*metrics.BaseStat
+	client   *httpClient
+	servers  []*haproxyServer
+	interval time.Duration
+	run      backgroundRun
+	totals   floatCounters
+}
+
+type haproxyServer struct {
+	name    string
+	address string
+	socket  bool
+}
+
+func (h *Haproxy) GetPrefix() string {
+	return h.Prefix
+}
+
+// Collect read stats in background, a slow socket or page don't delay other collectors
+func (h *Haproxy) Collect() {
+	h.run.start(h.interval, h.collectAll)
+}
+
+// collectAll read stats of servers concurrently
+func (h *Haproxy) collectAll() {
+	var wg sync.WaitGroup
+	for _, s := range h.servers {
+		wg.Add(1)
+		go func(s *haproxyServer) {
+			defer wg.Done()
+			h.collectServer(s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (h *Haproxy) collectServer(s *haproxyServer) {
+	var body []byte
+	var err error
+	if s.socket {
+		body, err = h.readSocket(s.address)
+	} else {
+		body, err = h.readHTTP(s.address)
+	}
+	if err != nil {
+		h.GaugeUpdate(s.name+".up", 0)
+		h.OnErr("error_read_stats", fmt.Errorf("error read stats from %s: %s", s.address, err))
+		return
+	}
+
+	records, err := parseHaproxyCSV(string(body))
+	if err != nil {
+		h.GaugeUpdate(s.name+".up", 0)
+		h.OnErr("error_parse_stats", fmt.Errorf("error parse stats from %s: %s", s.address, err))
+		return
+	}
+	h.GaugeUpdate(s.name+".up", 1)
+
+	for _, r := range records {
+		t, ok := haproxyTypes[r["type"]]
+		if !ok || t == "listener" {
+			continue
+		}
+		k := s.name + "." + t + "." + util.SanitizeKey(r["pxname"]) + "."
+		if t == "server" {
+			k += util.SanitizeKey(r["svname"]) + "."
+		}
+		for _, c := range haproxyGauges {
+			if v := r[c]; v != "" {
+				h.GaugeUpdate(k+c, v)
+			}
+		}
+		for _, c := range haproxyCounters {
+			if v, err := strconv.ParseFloat(r[c], 64); err == nil {
+				h.totals.incTotal(h.BaseStat, k+c, v)
+			}
+		}
+
+		// OPEN, UP, UP 1/3, DOWN, DOWN 1/2, NOLB, MAINT, DRAIN, no check
+		status := r["status"]
+		up := strings.HasPrefix(status, "UP") || status == "OPEN" ||
+			status == "NOLB" || status == "DRAIN" || status == "no check"
+		h.GaugeUpdate(k+"status.up", boolToInt(up))
+		h.GaugeUpdate(k+"status.maint", boolToInt(strings.HasPrefix(status, "MAINT")))
+
+		// L4OK, L6OK, L7OK, L4TOUT, L7STS ... empty if no health check
+		if check := strings.TrimPrefix(r["check_status"], "* "); check != "" {
+			h.GaugeUpdate(k+"check.ok", boolToInt(strings.HasSuffix(check, "OK")))
+		}
+	}
+}
+
+func (h *Haproxy) readSocket(path string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", path, RequestMadeTimeOutSec*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ResponseTimeoutSec * time.Second))
+	if _, err := conn.Write([]byte("show stat\n")); err != nil {
+		return nil, err
+	}
+	// haproxy close the connection after response in non-interactive mode
+	return ioutil.ReadAll(conn)
+}
+
+func (h *Haproxy) readHTTP(addr string) ([]byte, error) {
+	resp, err := h.client.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseHaproxyCSV parse output of 'show stat' to maps of column to value,
+// header line starts with '# '.
+func parseHaproxyCSV(body string) ([]map[string]string, error) {
+	body = strings.TrimPrefix(strings.TrimSpace(body), "# ")
+	r := csv.NewReader(strings.NewReader(body))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]map[string]string, 0)
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]string, len(header))
+		for i, v := range fields {
+			if i < len(header) && header[i] != "" {
+				record[header[i]] = v
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
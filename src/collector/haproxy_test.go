@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHaproxyCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []map[string]string
+		wantErr bool
+	}{
+		{
+			name: "show stat",
+			body: "# pxname,svname,qcur,scur,status,\n" +
+				"http-in,FRONTEND,,2,OPEN,\n" +
+				"web,web-01,0,1,UP,\n" +
+				"web,BACKEND,0,1,UP,\n\n",
+			want: []map[string]string{
+				{"pxname": "http-in", "svname": "FRONTEND", "qcur": "", "scur": "2", "status": "OPEN"},
+				{"pxname": "web", "svname": "web-01", "qcur": "0", "scur": "1", "status": "UP"},
+				{"pxname": "web", "svname": "BACKEND", "qcur": "0", "scur": "1", "status": "UP"},
+			},
+		},
+		{
+			name: "no header prefix",
+			body: "pxname,svname,scur\nweb,web-01,3\n",
+			want: []map[string]string{
+				{"pxname": "web", "svname": "web-01", "scur": "3"},
+			},
+		},
+		{
+			// rows of older versions may have fewer columns, extra ones are ignored
+			name: "ragged rows",
+			body: "# pxname,svname,scur\nweb,web-01\nweb,web-02,1,extra\n",
+			want: []map[string]string{
+				{"pxname": "web", "svname": "web-01"},
+				{"pxname": "web", "svname": "web-02", "scur": "1"},
+			},
+		},
+		{
+			name: "quoted field",
+			body: "# pxname,svname,last_chk\nweb,web-01,\"L7STS/503, Service Unavailable\"\n",
+			want: []map[string]string{
+				{"pxname": "web", "svname": "web-01", "last_chk": "L7STS/503, Service Unavailable"},
+			},
+		},
+		{
+			name: "header only",
+			body: "# pxname,svname,scur,\n",
+			want: []map[string]string{},
+		},
+		{
+			name:    "empty",
+			body:    "",
+			wantErr: true,
+		},
+		{
+			name:    "bad quote",
+			body:    "# pxname,svname\nweb,\"web-01\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseHaproxyCSV(tt.body)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error, got %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
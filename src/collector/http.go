@@ -0,0 +1,122 @@
+package collector
+
+/* http client shared by collectors which fetch status over http */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// time limit seconds for requests made
+	// http://docscn.studygolang.com/pkg/net/http/#Client
+	RequestMadeTimeOutSec = 3
+
+	// time limit seconds to wait for a server's response headers return
+	// http://docscn.studygolang.com/pkg/net/http/#Transport
+	ResponseTimeoutSec = 5
+)
+
+// HTTPConfig is embedded in config of collectors, so options are set in the
+// collector section itself, like:
+//
+//	[collector.nginx]
+//	url = "https://localhost/nginx_status"
+//	username = "status"
+//	password = "secret"
+//	insecure_skip_verify = true
+type HTTPConfig struct {
+	TimeoutSec         int    `toml:"timeout_sec"`
+	ResponseTimeoutSec int    `toml:"response_timeout_sec"`
+	Username           string `toml:"username"`
+	Password           string `toml:"password"`
+	// pem files for https
+	TLSCA              string `toml:"tls_ca"`
+	TLSCert            string `toml:"tls_cert"`
+	TLSKey             string `toml:"tls_key"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+}
+
+type httpClient struct {
+	*http.Client
+	conf HTTPConfig
+}
+
+// newHTTPClient return error if tls options can't be loaded
+func newHTTPClient(conf HTTPConfig) (*httpClient, error) {
+	timeout := conf.TimeoutSec
+	if timeout <= 0 {
+		timeout = RequestMadeTimeOutSec
+	}
+	responseTimeout := conf.ResponseTimeoutSec
+	if responseTimeout <= 0 {
+		responseTimeout = ResponseTimeoutSec
+	}
+	tr := &http.Transport{
+		ResponseHeaderTimeout: time.Duration(responseTimeout) * time.Second,
+	}
+	tlsConfig, err := conf.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	tr.TLSClientConfig = tlsConfig
+	c := &httpClient{
+		Client: &http.Client{
+			Transport: tr,
+			Timeout:   time.Duration(timeout) * time.Second,
+		},
+		conf: conf,
+	}
+	return c, nil
+}
+
+func (c HTTPConfig) tlsConfig() (*tls.Config, error) {
+	conf := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.TLSCA != "" {
+		pem, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.TLSCA)
+		}
+		conf.RootCAs = pool
+	}
+	if c.TLSCert != "" && c.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// NewRequest make a request with basic auth set
+func (c *httpClient) NewRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.conf.Username != "" || c.conf.Password != "" {
+		req.SetBasicAuth(c.conf.Username, c.conf.Password)
+	}
+	return req, nil
+}
+
+// Get send a GET request with basic auth set
+func (c *httpClient) Get(url string) (*http.Response, error) {
+	req, err := c.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
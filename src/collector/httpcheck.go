@@ -0,0 +1,281 @@
+/*
+probe http endpoints, like:
+
+	$ curl -o /dev/null -s -w "%{http_code} %{time_namelookup} %{time_connect} %{time_appconnect} %{time_starttransfer} %{time_total}" https://example.com/health
+	200 0.004 0.021 0.065 0.102 0.103
+
+a check is up if the request success, the status code is expected, the body match
+body_regex and the content length is in range, timings of the last request are
+reported, connections are not reused so every probe dial and handshake.
+
+metric key like: http_check.example_health.latency.ttfb_ms
+*/
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+const (
+	// max redirects followed if not set
+	DefaultHttpCheckMaxRedirects = 10
+
+	// seconds between checks if not set
+	DefaultHttpCheckIntervalSec = 10
+
+	// body read for regex and content length
+	httpCheckMaxBody = 1 << 20
+)
+
+type HttpCheckConfig struct {
+	Enable bool              `toml:"enable"`
+	Checks []HttpCheckTarget `toml:"check"`
+	// seconds between checks, not every collect as a check may take seconds
+	IntervalSec int `toml:"interval_sec"`
+	// auth and tls options for all checks
+	HTTPConfig
+	TagsConfig
+}
+
+// HttpCheckTarget is one endpoint to probe
+type HttpCheckTarget struct {
+	// first segment of metric key, host and path of url if empty
+	Name    string            `toml:"name"`
+	Url     string            `toml:"url"`
+	Method  string            `toml:"method"`
+	Headers map[string]string `toml:"headers"`
+	Body    string            `toml:"body"`
+	// status codes meaning up, 200-399 if empty
+	ExpectedStatus []int `toml:"expected_status"`
+	// body must match if set
+	BodyRegex string `toml:"body_regex"`
+	// limits of content length, not checked if 0
+	MinContentLength int64 `toml:"min_content_length"`
+	MaxContentLength int64 `toml:"max_content_length"`
+	// redirects are not followed by default, the 3xx response is checked
+	FollowRedirects bool `toml:"follow_redirects"`
+	MaxRedirects    int  `toml:"max_redirects"`
+	TimeoutSec      int  `toml:"timeout_sec"`
+}
+
+type httpCheckTarget struct {
+	HttpCheckTarget
+	client    *httpClient
+	bodyRegex *regexp.Regexp
+}
+
+func NewHttpCheck(registry metrics.Registry, conf HttpCheckConfig) *HttpCheck {
+	bc := metrics.NewBaseStat("http_check", registry)
+	h := &HttpCheck{
+		BaseStat: bc,
+		checks:   make([]*httpCheckTarget, 0, len(conf.Checks)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	if conf.IntervalSec <= 0 {
+		h.interval = DefaultHttpCheckIntervalSec * time.Second
+	}
+	for _, c := range conf.Checks {
+		check, err := newHttpCheckTarget(c, conf.HTTPConfig)
+		if err != nil {
+			h.OnErr("error_config", fmt.Errorf("error check %s: %s", c.Url, err))
+			continue
+		}
+		h.checks = append(h.checks, check)
+	}
+	return h
+}
+
+func newHttpCheckTarget(c HttpCheckTarget, conf HTTPConfig) (*httpCheckTarget, error) {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, err
+	}
+	if c.Name == "" {
+		c.Name = u.Host + u.Path
+	}
+	c.Name = util.SanitizeKey(c.Name)
+	if c.Method == "" {
+		c.Method = "GET"
+	}
+	if c.MaxRedirects <= 0 {
+		c.MaxRedirects = DefaultHttpCheckMaxRedirects
+	}
+	check := &httpCheckTarget{HttpCheckTarget: c}
+	if c.BodyRegex != "" {
+		re, err := regexp.Compile(c.BodyRegex)
+		if err != nil {
+			return nil, err
+		}
+		check.bodyRegex = re
+	}
+
+	if c.TimeoutSec > 0 {
+		conf.TimeoutSec = c.TimeoutSec
+	}
+	client, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport.(*http.Transport).DisableKeepAlives = true
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !c.FollowRedirects {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= c.MaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", c.MaxRedirects)
+		}
+		return nil
+	}
+	check.client = client
+	return check, nil
+}
+
+// HttpCheck probe one or more http endpoints
+type HttpCheck struct {
+	*metrics.BaseStat
+	checks   []*httpCheckTarget
+	interval time.Duration
+	run      backgroundRun
+}
+
+func (h *HttpCheck) GetPrefix() string {
+	return h.Prefix
+}
+
+// Collect start checks every interval in background, a run is finished when the
+// slowest endpoint is done or timed out.
+func (h *HttpCheck) Collect() {
+	h.run.start(h.interval, h.checkAll)
+}
+
+// checkAll run checks concurrently
+func (h *HttpCheck) checkAll() {
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c *httpCheckTarget) {
+			defer wg.Done()
+			h.check(c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (h *HttpCheck) check(c *httpCheckTarget) {
+	var body io.Reader
+	if c.Body != "" {
+		body = strings.NewReader(c.Body)
+	}
+	req, err := c.client.NewRequest(c.Method, c.Url, body)
+	if err != nil {
+		h.OnErr("error_config", fmt.Errorf("error request %s: %s", c.Url, err))
+		return
+	}
+	for k, v := range c.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	var start, dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { connectDone = time.Now() },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(context.Background(), trace))
+
+	start = time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		h.GaugeUpdate(c.Name+".up", 0)
+		h.OnErr("error_request", fmt.Errorf("error request %s: %s", c.Url, err))
+		return
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpCheckMaxBody))
+	total := time.Since(start)
+	if err != nil {
+		h.GaugeUpdate(c.Name+".up", 0)
+		h.OnErr("error_read_body", fmt.Errorf("error read body of %s: %s", c.Url, err))
+		return
+	}
+
+	h.GaugeFloat64Update(c.Name+".latency.total_ms", durationMs(total))
+	if !dnsStart.IsZero() && !dnsDone.IsZero() {
+		h.GaugeFloat64Update(c.Name+".latency.dns_ms", durationMs(dnsDone.Sub(dnsStart)))
+	}
+	if !connectStart.IsZero() && !connectDone.IsZero() {
+		h.GaugeFloat64Update(c.Name+".latency.connect_ms", durationMs(connectDone.Sub(connectStart)))
+	}
+	if !tlsStart.IsZero() && !tlsDone.IsZero() {
+		h.GaugeFloat64Update(c.Name+".latency.tls_ms", durationMs(tlsDone.Sub(tlsStart)))
+	}
+	if !firstByte.IsZero() {
+		h.GaugeFloat64Update(c.Name+".latency.ttfb_ms", durationMs(firstByte.Sub(start)))
+	}
+
+	h.GaugeUpdate(c.Name+".status_code", resp.StatusCode)
+	up := c.expectedStatus(resp.StatusCode)
+	h.GaugeUpdate(c.Name+".status_ok", boolToInt(up))
+
+	length := resp.ContentLength
+	if length < 0 {
+		length = int64(len(data))
+	}
+	h.GaugeUpdate(c.Name+".content_length", length)
+	if c.MinContentLength > 0 || c.MaxContentLength > 0 {
+		lengthOk := length >= c.MinContentLength && (c.MaxContentLength <= 0 || length <= c.MaxContentLength)
+		h.GaugeUpdate(c.Name+".content_length_ok", boolToInt(lengthOk))
+		up = up && lengthOk
+	}
+
+	if c.bodyRegex != nil {
+		match := c.bodyRegex.Match(data)
+		h.GaugeUpdate(c.Name+".body_match", boolToInt(match))
+		up = up && match
+	}
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		left := time.Until(resp.TLS.PeerCertificates[0].NotAfter)
+		h.GaugeFloat64Update(c.Name+".cert.expiry_days", left.Hours()/24)
+	}
+
+	h.GaugeUpdate(c.Name+".up", boolToInt(up))
+}
+
+func (c *httpCheckTarget) expectedStatus(code int) bool {
+	if len(c.ExpectedStatus) == 0 {
+		return code >= 200 && code < 400
+	}
+	for _, s := range c.ExpectedStatus {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
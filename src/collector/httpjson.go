@@ -0,0 +1,359 @@
+/*
+collect fields of json returned by http endpoints, like elasticsearch cluster health:
+
+	$ curl http://localhost:9200/_cluster/health
+	{"cluster_name":"es","status":"green","number_of_nodes":3,"active_shards":10,...}
+
+fields are selected by path like "status", "nodes.count", "queues[0].messages" or
+"$.nodes[\"node.1\"].load", numbers are reported as they are, booleans as 1 or 0,
+strings by enum table of the field, or as numbers if they can be parsed.
+if path select an object, all numeric fields under it are reported.
+
+arrays can be expanded, each item is named by value of its key field:
+
+	[[collector.httpjson.endpoint]]
+	name = "es"
+	url = "http://localhost:9200/_cluster/health"
+	  [[collector.httpjson.endpoint.field]]
+	  path = "status"
+	  enum = { green = 0, yellow = 1, red = 2 }
+
+	$ curl -u guest:guest http://localhost:15672/api/queues
+	[{"name":"jobs","messages":12,"message_stats":{"publish":1000}},...]
+
+	[[collector.httpjson.endpoint]]
+	name = "rabbitmq"
+	url = "http://localhost:15672/api/queues"
+	  [[collector.httpjson.endpoint.array]]
+	  name = "queue"
+	  path = "$"
+	  key_field = "name"
+	    [[collector.httpjson.endpoint.array.field]]
+	    path = "message_stats.publish"
+	    counter = true
+
+metric key like: httpjson.es.status, httpjson.rabbitmq.queue.jobs.message_stats.publish
+*/
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+type HttpjsonConfig struct {
+	Enable    bool               `toml:"enable"`
+	Endpoints []HttpjsonEndpoint `toml:"endpoint"`
+	// seconds between collects, every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	HTTPConfig
+	TagsConfig
+}
+
+// HttpjsonEndpoint is one url returning json
+type HttpjsonEndpoint struct {
+	// first segment of metric key
+	Name    string            `toml:"name"`
+	Url     string            `toml:"url"`
+	Headers map[string]string `toml:"headers"`
+	Fields  []HttpjsonField   `toml:"field"`
+	Arrays  []HttpjsonArray   `toml:"array"`
+}
+
+// HttpjsonField select a value of json
+type HttpjsonField struct {
+	Path string `toml:"path"`
+	// metric name, made by path if empty
+	Name string `toml:"name"`
+	// string value to number, like { green = 0, yellow = 1, red = 2 }
+	Enum map[string]interface{} `toml:"enum"`
+	// value only increase, reported as count and rate instead of gauge
+	Counter bool `toml:"counter"`
+}
+
+// HttpjsonArray expand an array, fields are selected from each item
+type HttpjsonArray struct {
+	Name string `toml:"name"`
+	Path string `toml:"path"`
+	// path of value in item which names the item, index of item if empty
+	KeyField string          `toml:"key_field"`
+	Fields   []HttpjsonField `toml:"field"`
+}
+
+func NewHttpjson(registry metrics.Registry, conf HttpjsonConfig) (*Httpjson, error) {
+	bc := metrics.NewBaseStat("httpjson", registry)
+	h := &Httpjson{
+		BaseStat:  bc,
+		Endpoints: make([]HttpjsonEndpoint, 0, len(conf.Endpoints)),
+		interval:  time.Duration(conf.IntervalSec) * time.Second,
+	}
+	var err error
+	h.client, err = newHTTPClient(conf.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range conf.Endpoints {
+		if e.Name == "" {
+			h.OnErr("error_config", fmt.Errorf("name of endpoint %s is empty", e.Url))
+			continue
+		}
+		e.Name = util.SanitizeKey(e.Name)
+		h.Endpoints = append(h.Endpoints, e)
+	}
+	return h, nil
+}
+
+// Httpjson collect fields of json from one or more http endpoints
+type Httpjson struct {
+	*metrics.BaseStat
+	Endpoints []HttpjsonEndpoint
+	client    *httpClient
+	totals    floatCounters
+	interval  time.Duration
+	run       backgroundRun
+}
+
+func (h *Httpjson) GetPrefix() string {
+	return h.Prefix
+}
+
+// Collect fetch endpoints in background, a slow endpoint don't delay other collectors
+func (h *Httpjson) Collect() {
+	h.run.start(h.interval, h.collectAll)
+}
+
+// collectAll fetch endpoints concurrently
+func (h *Httpjson) collectAll() {
+	var wg sync.WaitGroup
+	for _, e := range h.Endpoints {
+		wg.Add(1)
+		go func(e HttpjsonEndpoint) {
+			defer wg.Done()
+			h.collectEndpoint(e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (h *Httpjson) collectEndpoint(e HttpjsonEndpoint) {
+	doc, err := h.fetch(e)
+	if err != nil {
+		h.GaugeUpdate(e.Name+".up", 0)
+		h.OnErr("error_fetch", fmt.Errorf("error fetch %s: %s", e.Url, err))
+		return
+	}
+	h.GaugeUpdate(e.Name+".up", 1)
+
+	for _, f := range e.Fields {
+		h.collectField(e.Name, doc, f)
+	}
+
+	for _, a := range e.Arrays {
+		v, err := jsonSelect(doc, a.Path)
+		if err != nil {
+			h.OnErr("error_select", fmt.Errorf("error select %s of %s: %s", a.Path, e.Url, err))
+			continue
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			h.OnErr("error_select", fmt.Errorf("%s of %s is not an array", a.Path, e.Url))
+			continue
+		}
+		prefix := e.Name + "."
+		if a.Name != "" {
+			prefix += util.SanitizeKey(a.Name) + "."
+		}
+		for i, item := range items {
+			name := strconv.Itoa(i)
+			if a.KeyField != "" {
+				k, err := jsonSelect(item, a.KeyField)
+				if err != nil || k == nil {
+					continue
+				}
+				name = fmt.Sprint(k)
+			}
+			for _, f := range a.Fields {
+				h.collectField(prefix+util.SanitizeKey(name), item, f)
+			}
+		}
+	}
+}
+
+func (h *Httpjson) fetch(e HttpjsonEndpoint) (interface{}, error) {
+	req, err := h.client.NewRequest("GET", e.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (h *Httpjson) collectField(prefix string, doc interface{}, f HttpjsonField) {
+	v, err := jsonSelect(doc, f.Path)
+	if err != nil {
+		h.OnErr("error_select", fmt.Errorf("error select %s: %s", f.Path, err))
+		return
+	}
+	name := f.Name
+	if name == "" {
+		name = jsonPathKey(f.Path)
+	}
+	key := prefix
+	if name != "" {
+		key += "." + name
+	}
+
+	if obj, ok := v.(map[string]interface{}); ok {
+		h.collectObject(key, obj, f)
+		return
+	}
+	h.update(key, v, f)
+}
+
+// collectObject report all numeric fields under an object
+func (h *Httpjson) collectObject(key string, obj map[string]interface{}, f HttpjsonField) {
+	for k, v := range obj {
+		sub := key + "." + util.SanitizeKey(k)
+		if child, ok := v.(map[string]interface{}); ok {
+			h.collectObject(sub, child, f)
+			continue
+		}
+		if _, ok := v.(string); ok && f.Enum == nil {
+			// names, versions and so on
+			continue
+		}
+		h.update(sub, v, f)
+	}
+}
+
+func (h *Httpjson) update(key string, v interface{}, f HttpjsonField) {
+	n, ok := jsonNumber(v, f.Enum)
+	if !ok {
+		if v != nil {
+			h.OnErr("error_value", fmt.Errorf("value of %s is not a number: %v", key, v))
+		}
+		return
+	}
+	if f.Counter {
+		h.totals.incTotal(h.BaseStat, key, n)
+	} else {
+		h.GaugeFloat64Update(key, n)
+	}
+}
+
+// jsonNumber convert a json value to number, strings by enum table first
+func jsonNumber(v interface{}, enum map[string]interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		n, err := t.Float64()
+		return n, err == nil
+	case bool:
+		if t {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		if n, ok := enum[t]; ok {
+			return toFloat64(n)
+		}
+		n, err := strconv.ParseFloat(t, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// jsonSelect select value of doc by path like $.a.b[0]["c.d"], '$' or empty for doc itself
+func jsonSelect(doc interface{}, path string) (interface{}, error) {
+	tokens, err := jsonPathTokens(path)
+	if err != nil {
+		return nil, err
+	}
+	v := doc
+	for _, t := range tokens {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("no field %s", t)
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("bad index %s", t)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("no field %s", t)
+		}
+	}
+	return v, nil
+}
+
+// jsonPathTokens split path to field names and indexes
+func jsonPathTokens(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	tokens := make([]string, 0)
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad path %s", path)
+			}
+			t := strings.TrimSpace(path[i+1 : i+end])
+			tokens = append(tokens, strings.Trim(t, `"'`))
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			tokens = append(tokens, path[i:i+end])
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+// jsonPathKey make metric name from path, like nodes.node_1.load
+func jsonPathKey(path string) string {
+	tokens, _ := jsonPathTokens(path)
+	for i, t := range tokens {
+		tokens[i] = util.SanitizeKey(t)
+	}
+	return strings.Join(tokens, ".")
+}
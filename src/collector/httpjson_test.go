@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJsonPathTokens(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{}, false},
+		{"$", []string{}, false},
+		{"status", []string{"status"}, false},
+		{"nodes.count", []string{"nodes", "count"}, false},
+		{"$.nodes.count", []string{"nodes", "count"}, false},
+		{"queues[0].messages", []string{"queues", "0", "messages"}, false},
+		{"$[1]", []string{"1"}, false},
+		{`$.nodes["node.1"].load`, []string{"nodes", "node.1", "load"}, false},
+		{`nodes['node.1'][ 2 ]`, []string{"nodes", "node.1", "2"}, false},
+		{" $.a..b ", []string{"a", "b"}, false},
+		{"queues[0", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := jsonPathTokens(tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: want error, got %v", tt.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestJsonSelect(t *testing.T) {
+	var doc interface{}
+	d := json.NewDecoder(strings.NewReader(`{
+		"status": "green",
+		"nodes": {"count": 3, "node.1": {"load": 0.5}},
+		"queues": [{"name": "jobs", "messages": 12}, {"name": "mail", "messages": 0}]
+	}`))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		want    interface{}
+		wantErr bool
+	}{
+		{path: "status", want: "green"},
+		{path: "nodes.count", want: json.Number("3")},
+		{path: `$.nodes["node.1"].load`, want: json.Number("0.5")},
+		{path: "queues[1].name", want: "mail"},
+		{path: "queues[0]", want: map[string]interface{}{"name": "jobs", "messages": json.Number("12")}},
+		{path: "$", want: doc},
+		{path: "missing", wantErr: true},
+		{path: "queues[2]", wantErr: true},
+		{path: "queues[-1]", wantErr: true},
+		{path: "queues.name", wantErr: true},
+		{path: "status.length", wantErr: true},
+		{path: "queues[0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := jsonSelect(doc, tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error, got %v", tt.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
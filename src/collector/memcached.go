@@ -0,0 +1,211 @@
+/*
+collect memcached stats by text protocol:
+
+	$ printf "stats\r\n" | nc 127.0.0.1 11211
+	STAT pid 1162
+	STAT uptime 5022
+	STAT curr_connections 10
+	...
+	END
+
+"stats slabs" is sent too when slabs enabled, with lines like "STAT 1:chunk_size 96"
+
+detail in https://github.com/memcached/memcached/blob/master/doc/protocol.txt
+
+metric key like: memcached.127_0_0_1_11211.get_hits
+*/
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+type MemcachedConfig struct {
+	Enable bool `toml:"enable"`
+	// host:port for tcp, unix:///path/to/memcached.sock or /path/to/memcached.sock for unix socket
+	Servers []string `toml:"servers"`
+	// also send 'stats slabs' and report each slab class
+	Slabs bool `toml:"slabs"`
+	TagsConfig
+}
+
+// stats reported as they are
+var memcachedGauges = []string{
+	"uptime", "threads", "curr_connections", "curr_items", "bytes", "limit_maxbytes",
+}
+
+// stats which only increase, reported as count and rate
+var memcachedCounters = []string{
+	"cmd_get", "cmd_set", "cmd_flush", "get_hits", "get_misses", "get_expired",
+	"delete_hits", "delete_misses", "incr_hits", "incr_misses",
+	"evictions", "expired_unfetched", "evicted_unfetched", "reclaimed",
+	"total_connections", "rejected_connections", "listen_disabled_num", "conn_yields",
+	"total_items", "bytes_read", "bytes_written",
+}
+
+// stats of each slab class reported as they are
+var memcachedSlabGauges = []string{
+	"chunk_size", "chunks_per_page", "total_pages", "total_chunks",
+	"used_chunks", "free_chunks", "mem_requested",
+}
+
+func NewMemcached(registry metrics.Registry, conf MemcachedConfig) *Memcached {
+	bc := metrics.NewBaseStat("memcached", registry)
+	m := &Memcached{
+		BaseStat: bc,
+		Conf:     conf,
+		servers:  make([]*memcachedServer, 0, len(conf.Servers)),
+	}
+	for _, addr := range conf.Servers {
+		network, address := "tcp", strings.TrimSpace(addr)
+		if strings.HasPrefix(address, "unix://") || strings.HasPrefix(address, "/") {
+			network, address = "unix", strings.TrimPrefix(address, "unix://")
+		}
+		m.servers = append(m.servers, &memcachedServer{
+			name:    util.SanitizeKey(address),
+			network: network,
+			address: address,
+		})
+	}
+	return m
+}
+
+// Memcached collect stats of one or more memcached
+type Memcached struct {
+	*metrics.BaseStat
+	Conf    MemcachedConfig
+	servers []*memcachedServer
+}
+
+type memcachedServer struct {
+	name    string
+	network string
+	address string
+	// last get_hits and get_misses, for hit ratio
+	lastHits   int64
+	lastMisses int64
+}
+
+func (m *Memcached) GetPrefix() string {
+	return m.Prefix
+}
+
+func (m *Memcached) Collect() {
+	for _, s := range m.servers {
+		m.collectServer(s)
+	}
+}
+
+func (m *Memcached) collectServer(s *memcachedServer) {
+	conn, err := net.DialTimeout(s.network, s.address, RequestMadeTimeOutSec*time.Second)
+	if err != nil {
+		m.GaugeUpdate(s.name+".up", 0)
+		m.OnErr("error_dial", fmt.Errorf("error dial %s: %s", s.address, err))
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ResponseTimeoutSec * time.Second))
+	r := bufio.NewReader(conn)
+
+	stats, err := memcachedStats(conn, r, "stats")
+	if err != nil {
+		m.GaugeUpdate(s.name+".up", 0)
+		m.OnErr("error_stats", fmt.Errorf("error stats %s: %s", s.address, err))
+		return
+	}
+	m.GaugeUpdate(s.name+".up", 1)
+
+	for _, k := range memcachedGauges {
+		if v, ok := stats[k]; ok {
+			m.GaugeUpdate(s.name+"."+k, v)
+		}
+	}
+	for _, k := range memcachedCounters {
+		if v, ok := stats[k]; ok {
+			m.CounterIncTotal(s.name+"."+k, v)
+		}
+	}
+
+	used, err1 := strconv.ParseFloat(stats["bytes"], 64)
+	limit, err2 := strconv.ParseFloat(stats["limit_maxbytes"], 64)
+	if err1 == nil && err2 == nil && limit > 0 {
+		m.GaugeFloat64Update(s.name+".bytes_usage", 100*used/limit)
+	}
+
+	hits, err1 := strconv.ParseInt(stats["get_hits"], 10, 64)
+	misses, err2 := strconv.ParseInt(stats["get_misses"], 10, 64)
+	if err1 == nil && err2 == nil {
+		deltaHits, deltaMisses := hits-s.lastHits, misses-s.lastMisses
+		first := s.lastHits == 0 && s.lastMisses == 0
+		s.lastHits, s.lastMisses = hits, misses
+		if !first && deltaHits >= 0 && deltaMisses >= 0 && deltaHits+deltaMisses > 0 {
+			m.GaugeFloat64Update(s.name+".hit_ratio",
+				100*float64(deltaHits)/float64(deltaHits+deltaMisses))
+		}
+	}
+
+	if m.Conf.Slabs {
+		m.collectSlabs(s, conn, r)
+	}
+}
+
+func (m *Memcached) collectSlabs(s *memcachedServer, conn net.Conn, r *bufio.Reader) {
+	stats, err := memcachedStats(conn, r, "stats slabs")
+	if err != nil {
+		m.OnErr("error_stats_slabs", fmt.Errorf("error stats slabs %s: %s", s.address, err))
+		return
+	}
+	m.GaugeUpdate(s.name+".slabs.active_slabs", stats["active_slabs"])
+	m.GaugeUpdate(s.name+".slabs.total_malloced", stats["total_malloced"])
+
+	slabs := make(map[string]bool)
+	for k := range stats {
+		if i := strings.Index(k, ":"); i > 0 {
+			slabs[k[:i]] = true
+		}
+	}
+	for id := range slabs {
+		for _, k := range memcachedSlabGauges {
+			if v, ok := stats[id+":"+k]; ok {
+				m.GaugeUpdate(s.name+".slabs."+id+"."+k, v)
+			}
+		}
+		for _, k := range []string{"get_hits", "cmd_set"} {
+			if v, ok := stats[id+":"+k]; ok {
+				m.CounterIncTotal(s.name+".slabs."+id+"."+k, v)
+			}
+		}
+	}
+}
+
+// memcachedStats send a stats command and read 'STAT <name> <value>' lines until END
+func memcachedStats(conn net.Conn, r *bufio.Reader, cmd string) (map[string]string, error) {
+	if _, err := conn.Write([]byte(cmd + "\r\n")); err != nil {
+		return nil, err
+	}
+	stats := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "END" {
+			return stats, nil
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			// ERROR, CLIENT_ERROR <msg>, SERVER_ERROR <msg>
+			return nil, fmt.Errorf("unexpected response: %s", line)
+		}
+		stats[fields[1]] = fields[2]
+	}
+}
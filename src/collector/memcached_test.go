@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// fakeMemcached answer each command line read by the response of the command,
+// the connection is closed after an unknown command or a response without END
+func fakeMemcached(t *testing.T, responses map[string]string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					resp, ok := responses[strings.TrimSpace(line)]
+					if !ok {
+						return
+					}
+					conn.Write([]byte(resp))
+					if !strings.HasSuffix(resp, "END\r\n") {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestMemcachedStats(t *testing.T) {
+	addr := fakeMemcached(t, map[string]string{
+		"stats": "STAT pid 1234\r\nSTAT uptime 3600\r\nSTAT version 1.6.21\r\n" +
+			"STAT get_hits 90\r\nSTAT get_misses 10\r\nEND\r\n",
+		"stats slabs": "STAT 1:chunk_size 96\r\nSTAT 1:used_chunks 12\r\n" +
+			"STAT active_slabs 1\r\nSTAT total_malloced 1048576\r\nEND\r\n",
+		"stats empty": "END\r\n",
+		"stats bad":   "ERROR\r\n",
+		"stats short": "STAT pid 1234\r\n",
+	})
+
+	tests := []struct {
+		cmd     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			cmd: "stats",
+			want: map[string]string{
+				"pid": "1234", "uptime": "3600", "version": "1.6.21",
+				"get_hits": "90", "get_misses": "10",
+			},
+		},
+		{
+			cmd: "stats slabs",
+			want: map[string]string{
+				"1:chunk_size": "96", "1:used_chunks": "12",
+				"active_slabs": "1", "total_malloced": "1048576",
+			},
+		},
+		{cmd: "stats empty", want: map[string]string{}},
+		{cmd: "stats bad", wantErr: true},
+		// connection closed before END
+		{cmd: "stats short", wantErr: true},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		got, err := memcachedStats(conn, bufio.NewReader(conn), tt.cmd)
+		conn.Close()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error, got %v", tt.cmd, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.cmd, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+// commands on one connection are answered in order
+func TestMemcachedStatsSameConn(t *testing.T) {
+	addr := fakeMemcached(t, map[string]string{
+		"stats":       "STAT curr_items 5\r\nEND\r\n",
+		"stats slabs": "STAT active_slabs 2\r\nEND\r\n",
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, cmd := range []string{"stats", "stats slabs", "stats"} {
+		stats, err := memcachedStats(conn, r, cmd)
+		if err != nil {
+			t.Fatalf("%s: %s", cmd, err)
+		}
+		if len(stats) != 1 {
+			t.Errorf("%s: got %v", cmd, stats)
+		}
+	}
+}
+
+func TestMemcachedCollect(t *testing.T) {
+	addr := fakeMemcached(t, map[string]string{
+		"stats": "STAT curr_items 5\r\nSTAT bytes 256\r\nSTAT limit_maxbytes 1024\r\n" +
+			"STAT get_hits 90\r\nSTAT get_misses 10\r\nEND\r\n",
+		"stats slabs": "STAT 1:chunk_size 96\r\nSTAT 1:used_chunks 12\r\n" +
+			"STAT active_slabs 1\r\nSTAT total_malloced 1048576\r\nEND\r\n",
+	})
+	r := metrics.NewRegistry()
+	m := NewMemcached(r, MemcachedConfig{Servers: []string{addr}, Slabs: true})
+	m.Collect()
+
+	name := "memcached." + util.SanitizeKey(addr) + "."
+	tests := []struct {
+		key  string
+		want float64
+	}{
+		{"up", 1},
+		{"curr_items", 5},
+		{"bytes_usage", 25},
+		{"slabs.active_slabs", 1},
+		{"slabs.1.chunk_size", 96},
+		{"slabs.1.used_chunks", 12},
+	}
+	for _, tt := range tests {
+		var got float64
+		switch v := r.Get(name + tt.key).(type) {
+		case metrics.Gauge:
+			got = float64(v.Value())
+		case metrics.GaugeFloat64:
+			got = v.Value()
+		default:
+			t.Errorf("%s: not found", tt.key)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
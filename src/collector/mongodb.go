@@ -0,0 +1,315 @@
+/*
+collect mongodb status by command:
+
+db.adminCommand({serverStatus: 1})
+db.adminCommand({replSetGetStatus: 1})
+
+detail in https://docs.mongodb.com/manual/reference/command/serverStatus/
+
+metric key like: mongodb.127_0_0_1_27017.opcounters.query
+*/
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type MongodbConfig struct {
+	Enable bool `toml:"enable"`
+	// format: mongodb://[user:pass@]host[:port][/database][?options]
+	Servers []string `toml:"servers"`
+	// seconds between collects, every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	TagsConfig
+}
+
+// fields of serverStatus.wiredTiger.cache, reported as gauge
+var mongoCacheGauges = map[string]string{
+	"bytes currently in the cache":     "bytes_in_cache",
+	"maximum bytes configured":         "bytes_max",
+	"tracked dirty bytes in the cache": "bytes_dirty",
+}
+
+// fields of serverStatus.wiredTiger.cache, reported as count and rate
+var mongoCacheCounters = map[string]string{
+	"pages read into cache":                            "pages_read",
+	"pages written from cache":                         "pages_written",
+	"unmodified pages evicted":                         "evicted_unmodified",
+	"modified pages evicted":                           "evicted_modified",
+	"pages evicted by application threads":             "evicted_by_app",
+	"eviction worker thread evicting pages":            "evicted_by_worker",
+	"pages selected for eviction unable to be evicted": "evict_failed",
+}
+
+type mongoOpcounters struct {
+	Insert  int64 `bson:"insert"`
+	Query   int64 `bson:"query"`
+	Update  int64 `bson:"update"`
+	Delete  int64 `bson:"delete"`
+	Getmore int64 `bson:"getmore"`
+	Command int64 `bson:"command"`
+}
+
+type mongoServerStatus struct {
+	Uptime         int64           `bson:"uptime"`
+	Opcounters     mongoOpcounters `bson:"opcounters"`
+	OpcountersRepl mongoOpcounters `bson:"opcountersRepl"`
+	Connections    struct {
+		Current      int64 `bson:"current"`
+		Available    int64 `bson:"available"`
+		TotalCreated int64 `bson:"totalCreated"`
+	} `bson:"connections"`
+	Network struct {
+		BytesIn     int64 `bson:"bytesIn"`
+		BytesOut    int64 `bson:"bytesOut"`
+		NumRequests int64 `bson:"numRequests"`
+	} `bson:"network"`
+	GlobalLock struct {
+		CurrentQueue struct {
+			Total   int64 `bson:"total"`
+			Readers int64 `bson:"readers"`
+			Writers int64 `bson:"writers"`
+		} `bson:"currentQueue"`
+		ActiveClients struct {
+			Total   int64 `bson:"total"`
+			Readers int64 `bson:"readers"`
+			Writers int64 `bson:"writers"`
+		} `bson:"activeClients"`
+	} `bson:"globalLock"`
+	Mem struct {
+		Resident int64 `bson:"resident"`
+		Virtual  int64 `bson:"virtual"`
+	} `bson:"mem"`
+	WiredTiger *struct {
+		Cache bson.M `bson:"cache"`
+	} `bson:"wiredTiger"`
+}
+
+type mongoReplSetStatus struct {
+	Set     string `bson:"set"`
+	MyState int64  `bson:"myState"`
+	Members []struct {
+		Name       string    `bson:"name"`
+		Health     int64     `bson:"health"`
+		State      int64     `bson:"state"`
+		OptimeDate time.Time `bson:"optimeDate"`
+		Self       bool      `bson:"self"`
+	} `bson:"members"`
+}
+
+// replica set member state PRIMARY, see
+// https://docs.mongodb.com/manual/reference/replica-states/
+const mongoStatePrimary = 1
+
+func NewMongodb(registry metrics.Registry, conf MongodbConfig) *Mongodb {
+	bc := metrics.NewBaseStat("mongodb", registry)
+	m := &Mongodb{
+		BaseStat: bc,
+		servers:  make([]*mongoServer, 0, len(conf.Servers)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	for _, u := range conf.Servers {
+		opts := options.Client().ApplyURI(u)
+		if err := opts.Validate(); err != nil || len(opts.Hosts) == 0 {
+			m.OnErr("error_parse_url", fmt.Errorf("error parse url '%s': %v", u, err))
+			continue
+		}
+		// stats of a replica set member should come from itself, the first host is
+		// connected directly
+		opts.SetHosts(opts.Hosts[:1]).SetDirect(true).
+			SetConnectTimeout(RequestMadeTimeOutSec * time.Second).
+			SetServerSelectionTimeout(RequestMadeTimeOutSec * time.Second)
+		m.servers = append(m.servers, &mongoServer{
+			name:    util.SanitizeKey(opts.Hosts[0]),
+			opts:    opts,
+			members: make(map[string]bool),
+		})
+	}
+	return m
+}
+
+// Mongodb collect status of one or more mongod
+type Mongodb struct {
+	*metrics.BaseStat
+	servers  []*mongoServer
+	interval time.Duration
+	run      backgroundRun
+	totals   floatCounters
+}
+
+type mongoServer struct {
+	name   string
+	opts   *options.ClientOptions
+	client *mongo.Client
+	// replica set members seen in last collect
+	members map[string]bool
+}
+
+func (m *Mongodb) GetPrefix() string {
+	return m.Prefix
+}
+
+// Collect run commands in background, a slow mongod don't delay other collectors,
+// and is not asked again until last commands are done.
+func (m *Mongodb) Collect() {
+	m.run.start(m.interval, m.collectAll)
+}
+
+// collectAll run commands on servers concurrently
+func (m *Mongodb) collectAll() {
+	var wg sync.WaitGroup
+	for _, s := range m.servers {
+		wg.Add(1)
+		go func(s *mongoServer) {
+			defer wg.Done()
+			m.collectServer(s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+// runCommand run an admin command and decode the result
+func (s *mongoServer) runCommand(name string, result interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ResponseTimeoutSec*time.Second)
+	defer cancel()
+	return s.client.Database("admin").RunCommand(ctx, bson.D{{Key: name, Value: 1}}).Decode(result)
+}
+
+func (m *Mongodb) collectServer(s *mongoServer) {
+	if s.client == nil {
+		// connections are made and remade by the driver in background
+		client, err := mongo.Connect(s.opts)
+		if err != nil {
+			m.GaugeUpdate(s.name+".up", 0)
+			m.OnErr("error_dial", fmt.Errorf("error dial %s: %s", s.name, err))
+			return
+		}
+		s.client = client
+	}
+
+	status := mongoServerStatus{}
+	err := s.runCommand("serverStatus", &status)
+	if err != nil {
+		m.GaugeUpdate(s.name+".up", 0)
+		m.OnErr("error_server_status", fmt.Errorf("error run serverStatus on %s: %s", s.name, err))
+		return
+	}
+	m.GaugeUpdate(s.name+".up", 1)
+	m.GaugeUpdate(s.name+".uptime", status.Uptime)
+
+	m.collectOpcounters(s.name+".opcounters.", status.Opcounters)
+	m.collectOpcounters(s.name+".opcounters_repl.", status.OpcountersRepl)
+
+	m.GaugeUpdate(s.name+".connections.current", status.Connections.Current)
+	m.GaugeUpdate(s.name+".connections.available", status.Connections.Available)
+	m.totals.incTotal(m.BaseStat, s.name+".connections.created", float64(status.Connections.TotalCreated))
+
+	m.totals.incTotal(m.BaseStat, s.name+".network.bytes_in", float64(status.Network.BytesIn))
+	m.totals.incTotal(m.BaseStat, s.name+".network.bytes_out", float64(status.Network.BytesOut))
+	m.totals.incTotal(m.BaseStat, s.name+".network.requests", float64(status.Network.NumRequests))
+
+	gl := status.GlobalLock
+	m.GaugeUpdate(s.name+".global_lock.queue.total", gl.CurrentQueue.Total)
+	m.GaugeUpdate(s.name+".global_lock.queue.readers", gl.CurrentQueue.Readers)
+	m.GaugeUpdate(s.name+".global_lock.queue.writers", gl.CurrentQueue.Writers)
+	m.GaugeUpdate(s.name+".global_lock.active.total", gl.ActiveClients.Total)
+	m.GaugeUpdate(s.name+".global_lock.active.readers", gl.ActiveClients.Readers)
+	m.GaugeUpdate(s.name+".global_lock.active.writers", gl.ActiveClients.Writers)
+
+	// MB
+	m.GaugeUpdate(s.name+".mem.resident", status.Mem.Resident)
+	m.GaugeUpdate(s.name+".mem.virtual", status.Mem.Virtual)
+
+	if status.WiredTiger != nil {
+		m.collectWiredTigerCache(s.name+".wiredtiger.cache.", status.WiredTiger.Cache)
+	}
+
+	m.collectReplSet(s)
+}
+
+func (m *Mongodb) collectOpcounters(prefix string, op mongoOpcounters) {
+	m.totals.incTotal(m.BaseStat, prefix+"insert", float64(op.Insert))
+	m.totals.incTotal(m.BaseStat, prefix+"query", float64(op.Query))
+	m.totals.incTotal(m.BaseStat, prefix+"update", float64(op.Update))
+	m.totals.incTotal(m.BaseStat, prefix+"delete", float64(op.Delete))
+	m.totals.incTotal(m.BaseStat, prefix+"getmore", float64(op.Getmore))
+	m.totals.incTotal(m.BaseStat, prefix+"command", float64(op.Command))
+}
+
+func (m *Mongodb) collectWiredTigerCache(prefix string, cache bson.M) {
+	for k, name := range mongoCacheGauges {
+		if v, ok := toFloat64(cache[k]); ok {
+			m.GaugeUpdate(prefix+name, v)
+		}
+	}
+	for k, name := range mongoCacheCounters {
+		if v, ok := toFloat64(cache[k]); ok {
+			m.totals.incTotal(m.BaseStat, prefix+name, v)
+		}
+	}
+	inCache, ok1 := toFloat64(cache["bytes currently in the cache"])
+	maxBytes, ok2 := toFloat64(cache["maximum bytes configured"])
+	if ok1 && ok2 && maxBytes > 0 {
+		m.GaugeFloat64Update(prefix+"usage", 100*inCache/maxBytes)
+	}
+}
+
+func (m *Mongodb) collectReplSet(s *mongoServer) {
+	rs := mongoReplSetStatus{}
+	err := s.runCommand("replSetGetStatus", &rs)
+	if err != nil {
+		// standalone mongod answers NoReplicationEnabled, 'not running with --replSet'
+		var ce mongo.CommandError
+		if errors.As(err, &ce) && (ce.Code == 76 || strings.Contains(ce.Message, "--replSet")) {
+			return
+		}
+		m.OnErr("error_repl_set_status", fmt.Errorf("error run replSetGetStatus on %s: %s", s.name, err))
+		return
+	}
+
+	var primaryOptime time.Time
+	for _, member := range rs.Members {
+		if member.State == mongoStatePrimary {
+			primaryOptime = member.OptimeDate
+		}
+	}
+
+	m.GaugeUpdate(s.name+".replset.my_state", rs.MyState)
+	seen := make(map[string]bool, len(rs.Members))
+	for _, member := range rs.Members {
+		k := s.name + ".replset.members." + util.SanitizeKey(member.Name)
+		seen[member.Name] = true
+		m.GaugeUpdate(k+".state", member.State)
+		m.GaugeUpdate(k+".health", member.Health)
+		if primaryOptime.IsZero() || member.OptimeDate.IsZero() {
+			continue
+		}
+		lag := primaryOptime.Sub(member.OptimeDate).Seconds()
+		if lag < 0 {
+			lag = 0
+		}
+		m.GaugeUpdate(k+".lag", int64(lag))
+		if member.Self {
+			m.GaugeUpdate(s.name+".replset.lag", int64(lag))
+		}
+	}
+	for name := range s.members {
+		if !seen[name] {
+			// removed from replica set
+			k := s.name + ".replset.members." + util.SanitizeKey(name)
+			m.GaugeUpdate(k+".health", 0)
+		}
+	}
+	s.members = seen
+}
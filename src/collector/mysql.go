@@ -0,0 +1,348 @@
+/*
+collect mysql / mariadb status by sql:
+
+SHOW GLOBAL STATUS
+SHOW GLOBAL VARIABLES
+SHOW REPLICA STATUS  (SHOW SLAVE STATUS before mysql 8.0.22 / mariadb 10.5.1)
+SELECT ... FROM information_schema.PROCESSLIST
+
+metric key like: mysql.127_0_0_1_3306.threads.running
+*/
+package collector
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+	"github.com/go-sql-driver/mysql"
+)
+
+type MysqlConfig struct {
+	Enable bool `toml:"enable"`
+	// dsn list, format: https://github.com/go-sql-driver/mysql#dsn-data-source-name
+	Servers []string `toml:"servers"`
+	// seconds between collects, every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	TagsConfig
+}
+
+// status variables which only increase, reported as count and rate
+var mysqlStatusCounters = map[string]string{
+	"queries":                           "queries",
+	"questions":                         "questions",
+	"com_select":                        "com.select",
+	"com_insert":                        "com.insert",
+	"com_update":                        "com.update",
+	"com_delete":                        "com.delete",
+	"com_replace":                       "com.replace",
+	"com_commit":                        "com.commit",
+	"com_rollback":                      "com.rollback",
+	"slow_queries":                      "slow_queries",
+	"connections":                       "connections.total",
+	"aborted_connects":                  "connections.aborted_connects",
+	"aborted_clients":                   "connections.aborted_clients",
+	"threads_created":                   "threads.created",
+	"bytes_received":                    "net.bytes_received",
+	"bytes_sent":                        "net.bytes_sent",
+	"created_tmp_tables":                "tmp.tables",
+	"created_tmp_disk_tables":           "tmp.disk_tables",
+	"select_full_join":                  "select.full_join",
+	"select_scan":                       "select.scan",
+	"sort_merge_passes":                 "sort.merge_passes",
+	"table_locks_waited":                "locks.table_waited",
+	"innodb_row_lock_waits":             "innodb.row_lock.waits",
+	"innodb_row_lock_time":              "innodb.row_lock.time",
+	"innodb_deadlocks":                  "innodb.deadlocks",
+	"innodb_rows_read":                  "innodb.rows.read",
+	"innodb_rows_inserted":              "innodb.rows.inserted",
+	"innodb_rows_updated":               "innodb.rows.updated",
+	"innodb_rows_deleted":               "innodb.rows.deleted",
+	"innodb_buffer_pool_read_requests":  "innodb.buffer_pool.read_requests",
+	"innodb_buffer_pool_reads":          "innodb.buffer_pool.reads",
+	"innodb_buffer_pool_write_requests": "innodb.buffer_pool.write_requests",
+	"innodb_buffer_pool_wait_free":      "innodb.buffer_pool.wait_free",
+	"innodb_data_read":                  "innodb.data.read",
+	"innodb_data_written":               "innodb.data.written",
+	"innodb_data_fsyncs":                "innodb.data.fsyncs",
+	"innodb_log_waits":                  "innodb.log.waits",
+	"innodb_os_log_written":             "innodb.log.written",
+}
+
+// status variables reported as they are
+var mysqlStatusGauges = map[string]string{
+	"uptime":                         "uptime",
+	"threads_connected":              "threads.connected",
+	"threads_running":                "threads.running",
+	"threads_cached":                 "threads.cached",
+	"max_used_connections":           "connections.max_used",
+	"open_tables":                    "open.tables",
+	"open_files":                     "open.files",
+	"innodb_row_lock_current_waits":  "innodb.row_lock.current_waits",
+	"innodb_buffer_pool_pages_total": "innodb.buffer_pool.pages_total",
+	"innodb_buffer_pool_pages_free":  "innodb.buffer_pool.pages_free",
+	"innodb_buffer_pool_pages_data":  "innodb.buffer_pool.pages_data",
+	"innodb_buffer_pool_pages_dirty": "innodb.buffer_pool.pages_dirty",
+}
+
+func NewMysql(registry metrics.Registry, conf MysqlConfig) *Mysql {
+	bc := metrics.NewBaseStat("mysql", registry)
+	m := &Mysql{
+		BaseStat: bc,
+		servers:  make([]*mysqlServer, 0, len(conf.Servers)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	for _, dsn := range conf.Servers {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			m.OnErr("error_parse_dsn", fmt.Errorf("error parse dsn '%s': %s", dsn, err))
+			continue
+		}
+		if cfg.Timeout == 0 {
+			cfg.Timeout = RequestMadeTimeOutSec * time.Second
+		}
+		if cfg.ReadTimeout == 0 {
+			cfg.ReadTimeout = ResponseTimeoutSec * time.Second
+		}
+		db, err := sql.Open("mysql", cfg.FormatDSN())
+		if err != nil {
+			m.OnErr("error_open_db", fmt.Errorf("error open '%s': %s", cfg.Addr, err))
+			continue
+		}
+		db.SetMaxOpenConns(1)
+		m.servers = append(m.servers, &mysqlServer{
+			name:   util.SanitizeKey(cfg.Addr),
+			db:     db,
+			states: make(map[string]bool),
+		})
+	}
+	return m
+}
+
+// Mysql collect status of one or more mysql servers
+type Mysql struct {
+	*metrics.BaseStat
+	servers  []*mysqlServer
+	interval time.Duration
+	run      backgroundRun
+	totals   floatCounters
+}
+
+type mysqlServer struct {
+	name string
+	db   *sql.DB
+	// statement used for replication status, decided on first collect
+	replicaStmt string
+	// last value of buffer pool read_requests and reads, for hit ratio
+	lastReadRequests int64
+	lastReads        int64
+	// thread states seen in last collect, reset to 0 when they disappear
+	states map[string]bool
+}
+
+func (m *Mysql) GetPrefix() string {
+	return m.Prefix
+}
+
+// Collect query servers in background, a slow or locked server don't delay other
+// collectors, and is not queried again until last queries are done.
+func (m *Mysql) Collect() {
+	m.run.start(m.interval, m.collectAll)
+}
+
+// collectAll query servers concurrently
+func (m *Mysql) collectAll() {
+	var wg sync.WaitGroup
+	for _, s := range m.servers {
+		wg.Add(1)
+		go func(s *mysqlServer) {
+			defer wg.Done()
+			m.collectServer(s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (m *Mysql) collectServer(s *mysqlServer) {
+	status, err := mysqlKeyValues(s.db, "SHOW GLOBAL STATUS")
+	if err != nil {
+		m.GaugeUpdate(s.name+".up", 0)
+		m.OnErr("error_global_status", fmt.Errorf("error query status of %s: %s", s.name, err))
+		return
+	}
+	m.GaugeUpdate(s.name+".up", 1)
+
+	for k, name := range mysqlStatusCounters {
+		if v, err := strconv.ParseFloat(status[k], 64); err == nil {
+			m.totals.incTotal(m.BaseStat, s.name+"."+name, v)
+		}
+	}
+	for k, name := range mysqlStatusGauges {
+		if v, ok := status[k]; ok {
+			m.GaugeUpdate(s.name+"."+name, v)
+		}
+	}
+
+	variables, err := mysqlKeyValues(s.db, "SHOW GLOBAL VARIABLES")
+	if err != nil {
+		m.OnErr("error_global_variables", fmt.Errorf("error query variables of %s: %s", s.name, err))
+	} else {
+		maxConn, _ := strconv.ParseFloat(variables["max_connections"], 64)
+		connected, _ := strconv.ParseFloat(status["threads_connected"], 64)
+		m.GaugeUpdate(s.name+".connections.max_connections", int64(maxConn))
+		if maxConn > 0 {
+			m.GaugeFloat64Update(s.name+".connections.usage", 100*connected/maxConn)
+		}
+		m.GaugeUpdate(s.name+".innodb.buffer_pool.size", variables["innodb_buffer_pool_size"])
+	}
+
+	m.collectBufferPoolHit(s, status)
+	m.collectThreadStates(s)
+	m.collectReplication(s)
+}
+
+func (m *Mysql) collectBufferPoolHit(s *mysqlServer, status map[string]string) {
+	requests, err1 := strconv.ParseInt(status["innodb_buffer_pool_read_requests"], 10, 64)
+	reads, err2 := strconv.ParseInt(status["innodb_buffer_pool_reads"], 10, 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	deltaRequests := requests - s.lastReadRequests
+	deltaReads := reads - s.lastReads
+	first := s.lastReadRequests == 0
+	s.lastReadRequests, s.lastReads = requests, reads
+	if first || deltaRequests <= 0 || deltaReads < 0 {
+		return
+	}
+	m.GaugeFloat64Update(s.name+".innodb.buffer_pool.hit_ratio",
+		100*(1-float64(deltaReads)/float64(deltaRequests)))
+}
+
+func (m *Mysql) collectThreadStates(s *mysqlServer) {
+	rows, err := s.db.Query("SELECT COALESCE(NULLIF(state, ''), command), COUNT(*) " +
+		"FROM information_schema.PROCESSLIST GROUP BY 1")
+	if err != nil {
+		m.OnErr("error_processlist", fmt.Errorf("error query processlist of %s: %s", s.name, err))
+		return
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var state string
+		var count int64
+		if err := rows.Scan(&state, &count); err != nil {
+			m.OnErr("error_processlist", err)
+			return
+		}
+		k := util.SanitizeKey(state)
+		if k == "" {
+			k = "none"
+		}
+		counts[k] += count
+	}
+	for k := range s.states {
+		if _, ok := counts[k]; !ok {
+			m.GaugeUpdate(s.name+".threads.state."+k, 0)
+			delete(s.states, k)
+		}
+	}
+	for k, v := range counts {
+		m.GaugeUpdate(s.name+".threads.state."+k, v)
+		s.states[k] = true
+	}
+}
+
+func (m *Mysql) collectReplication(s *mysqlServer) {
+	var rows *sql.Rows
+	var err error
+	if s.replicaStmt != "" {
+		rows, err = s.db.Query(s.replicaStmt)
+	} else {
+		for _, stmt := range []string{"SHOW REPLICA STATUS", "SHOW SLAVE STATUS"} {
+			if rows, err = s.db.Query(stmt); err == nil {
+				s.replicaStmt = stmt
+				break
+			}
+		}
+	}
+	if err != nil {
+		m.OnErr("error_replica_status", fmt.Errorf("error query replica status of %s: %s", s.name, err))
+		return
+	}
+	defer rows.Close()
+
+	status, err := mysqlRowMap(rows)
+	if err != nil {
+		m.OnErr("error_replica_status", err)
+		return
+	}
+	if status == nil {
+		// not a replica
+		return
+	}
+
+	ioRunning := firstOf(status, "replica_io_running", "slave_io_running") == "Yes"
+	sqlRunning := firstOf(status, "replica_sql_running", "slave_sql_running") == "Yes"
+	behind, err := strconv.ParseInt(firstOf(status, "seconds_behind_source", "seconds_behind_master"), 10, 64)
+	if err != nil {
+		// NULL when sql thread not running or io thread not connected
+		behind = -1
+	}
+	m.GaugeUpdate(s.name+".replication.io_running", boolToInt(ioRunning))
+	m.GaugeUpdate(s.name+".replication.sql_running", boolToInt(sqlRunning))
+	m.GaugeUpdate(s.name+".replication.seconds_behind", behind)
+	m.GaugeUpdate(s.name+".replication.healthy", boolToInt(ioRunning && sqlRunning && behind >= 0))
+	m.GaugeUpdate(s.name+".replication.last_errno", status["last_errno"])
+}
+
+// mysqlKeyValues query statements like 'SHOW GLOBAL STATUS' which return
+// rows of name and value, name is lowercased
+func mysqlKeyValues(db *sql.DB, query string) (map[string]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	kv := make(map[string]string)
+	for rows.Next() {
+		var k string
+		var v sql.RawBytes
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		kv[strings.ToLower(k)] = string(v)
+	}
+	return kv, rows.Err()
+}
+
+// mysqlRowMap read first row to a map of lowercased column name to value,
+// return nil map if no row.
+func mysqlRowMap(rows *sql.Rows) (map[string]string, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	values := make([]sql.RawBytes, len(cols))
+	args := make([]interface{}, len(cols))
+	for i := range values {
+		args[i] = &values[i]
+	}
+	if err := rows.Scan(args...); err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(cols))
+	for i, c := range cols {
+		row[strings.ToLower(c)] = string(values[i])
+	}
+	return row, nil
+}
@@ -16,33 +16,30 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/coder-van/v-stats/metrics"
 )
 
-const (
-	// time limit seconds for requests made
-	// http://docscn.studygolang.com/pkg/net/http/#Client
-	RequestMadeTimeOutSec = 3
-	
-	// time limit seconds to wait for a server's response headers return
-	// http://docscn.studygolang.com/pkg/net/http/#Transport
-	ResponseTimeoutSec  = 5
-)
-
 type NginxConfig struct {
 	Enable     bool    `toml:"enable"`
 	Url        string  `toml:"url"`
+	HTTPConfig
+	TagsConfig
 }
 
 // NewNginx XXX
-func NewNginx(registry metrics.Registry, conf NginxConfig) *Nginx {
+func NewNginx(registry metrics.Registry, conf NginxConfig) (*Nginx, error) {
 	bc := metrics.NewBaseStat("nginx", registry)
-	return &Nginx{
+	n := &Nginx{
 		BaseStat: bc,
 		StatusURL: conf.Url,
 	}
+	var err error
+	n.client, err = newHTTPClient(conf.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
 }
 
 func (n *Nginx) GetPrefix() string {
@@ -53,15 +50,7 @@ func (n *Nginx) GetPrefix() string {
 type Nginx struct {
 	*metrics.BaseStat
 	StatusURL string
-}
-
-var tr = &http.Transport{
-	ResponseHeaderTimeout: time.Duration(ResponseTimeoutSec * time.Second),
-}
-
-var client = &http.Client{
-	Transport: tr,
-	Timeout:   time.Duration(RequestMadeTimeOutSec * time.Second),
+	client    *httpClient
 }
 
 func (n *Nginx) Register() {
@@ -88,7 +77,7 @@ func (n *Nginx) Collect() {
 			fmt.Errorf("Error parse address '%s': %s", n.StatusURL, err))
 	}
 
-	resp, err := client.Get(addr.String())
+	resp, err := n.client.Get(addr.String())
 	if err != nil {
 		n.OnErr("error_making_request",
 			 fmt.Errorf("error making HTTP request to %s: %s", addr.String(), err))
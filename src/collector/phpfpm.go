@@ -0,0 +1,235 @@
+/*
+collect php-fpm pool status, by http through web server or fastcgi to the pool directly:
+
+	$ curl "http://localhost/status?json&full"
+	$ SCRIPT_NAME=/status SCRIPT_FILENAME=/status QUERY_STRING="json&full" REQUEST_METHOD=GET \
+		cgi-fcgi -bind -connect /run/php/php-fpm.sock
+
+	{"pool":"www","process manager":"dynamic","start time":1700000000,"start since":3600,
+	"accepted conn":1034,"listen queue":0,"max listen queue":2,"listen queue len":511,
+	"idle processes":4,"active processes":1,"total processes":5,"max active processes":3,
+	"max children reached":0,"slow requests":0,"processes":[{"pid":1201,"state":"Idle",...},...]}
+
+pm.status_path must be set in pool config, detail in
+https://www.php.net/manual/en/fpm.status.php
+
+metric key like: phpfpm.run_php_php-fpm_sock.www.active_processes
+*/
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+const phpfpmQuery = "json&full"
+
+type PhpfpmConfig struct {
+	Enable bool `toml:"enable"`
+	// http://localhost/status for status page served by web server,
+	// fcgi://127.0.0.1:9000/status for pool listening on tcp,
+	// unix:///run/php/php-fpm.sock or /run/php/php-fpm.sock for pool listening on unix socket
+	Servers []string `toml:"servers"`
+	// pm.status_path of pools, used for unix socket and fcgi url without path, default /status
+	StatusPath string `toml:"status_path"`
+	// seconds between collects, every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	HTTPConfig
+	TagsConfig
+}
+
+type phpfpmStatus struct {
+	Pool               string `json:"pool"`
+	StartSince         int64  `json:"start since"`
+	AcceptedConn       int64  `json:"accepted conn"`
+	ListenQueue        int64  `json:"listen queue"`
+	MaxListenQueue     int64  `json:"max listen queue"`
+	ListenQueueLen     int64  `json:"listen queue len"`
+	IdleProcesses      int64  `json:"idle processes"`
+	ActiveProcesses    int64  `json:"active processes"`
+	TotalProcesses     int64  `json:"total processes"`
+	MaxActiveProcesses int64  `json:"max active processes"`
+	MaxChildrenReached int64  `json:"max children reached"`
+	SlowRequests       int64  `json:"slow requests"`
+	Processes          []struct {
+		State string `json:"state"`
+	} `json:"processes"`
+}
+
+func NewPhpfpm(registry metrics.Registry, conf PhpfpmConfig) (*Phpfpm, error) {
+	bc := metrics.NewBaseStat("phpfpm", registry)
+	p := &Phpfpm{
+		BaseStat: bc,
+		servers:  make([]*phpfpmServer, 0, len(conf.Servers)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	var err error
+	p.client, err = newHTTPClient(conf.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	statusPath := conf.StatusPath
+	if statusPath == "" {
+		statusPath = "/status"
+	}
+	for _, addr := range conf.Servers {
+		addr = strings.TrimSpace(addr)
+		s := &phpfpmServer{
+			address: addr,
+			path:    statusPath,
+			states:  make(map[string]bool),
+		}
+		switch {
+		case strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://"):
+			s.network = "http"
+			if !strings.Contains(addr, "?") {
+				s.address = addr + "?" + phpfpmQuery
+			}
+			s.name = util.SanitizeKey(strings.SplitN(addr, "://", 2)[1])
+		case strings.HasPrefix(addr, "fcgi://"):
+			u, err := url.Parse(addr)
+			if err != nil {
+				p.OnErr("error_config", fmt.Errorf("error parse %s: %s", addr, err))
+				continue
+			}
+			s.network, s.address = "tcp", u.Host
+			if u.Path != "" {
+				s.path = u.Path
+			}
+			s.name = util.SanitizeKey(u.Host)
+		default:
+			s.network, s.address = "unix", strings.TrimPrefix(addr, "unix://")
+			s.name = util.SanitizeKey(s.address)
+		}
+		p.servers = append(p.servers, s)
+	}
+	return p, nil
+}
+
+// Phpfpm collect status of one or more php-fpm pools
+type Phpfpm struct {
+	*metrics.BaseStat
+	client   *httpClient
+	servers  []*phpfpmServer
+	interval time.Duration
+	run      backgroundRun
+	totals   floatCounters
+}
+
+type phpfpmServer struct {
+	name string
+	// http, tcp or unix
+	network string
+	address string
+	// status path for fastcgi
+	path string
+	// process states seen, reset to 0 when gone
+	states map[string]bool
+}
+
+func (p *Phpfpm) GetPrefix() string {
+	return p.Prefix
+}
+
+// Collect fetch status of pools in background, a busy pool don't delay other collectors
+func (p *Phpfpm) Collect() {
+	p.run.start(p.interval, p.collectAll)
+}
+
+// collectAll fetch status of pools concurrently
+func (p *Phpfpm) collectAll() {
+	var wg sync.WaitGroup
+	for _, s := range p.servers {
+		wg.Add(1)
+		go func(s *phpfpmServer) {
+			defer wg.Done()
+			p.collectServer(s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (p *Phpfpm) collectServer(s *phpfpmServer) {
+	var body []byte
+	var err error
+	if s.network == "http" {
+		body, err = p.readHTTP(s.address)
+	} else {
+		body, err = p.readFcgi(s)
+	}
+	if err != nil {
+		p.GaugeUpdate(s.name+".up", 0)
+		p.OnErr("error_read_status", fmt.Errorf("error read status from %s: %s", s.address, err))
+		return
+	}
+
+	var status phpfpmStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		p.GaugeUpdate(s.name+".up", 0)
+		p.OnErr("error_parse_status", fmt.Errorf("error parse status from %s: %s", s.address, err))
+		return
+	}
+	p.GaugeUpdate(s.name+".up", 1)
+
+	k := s.name + "." + util.SanitizeKey(status.Pool) + "."
+	p.GaugeUpdate(k+"start_since", status.StartSince)
+	p.GaugeUpdate(k+"listen_queue", status.ListenQueue)
+	p.GaugeUpdate(k+"max_listen_queue", status.MaxListenQueue)
+	p.GaugeUpdate(k+"listen_queue_len", status.ListenQueueLen)
+	p.GaugeUpdate(k+"idle_processes", status.IdleProcesses)
+	p.GaugeUpdate(k+"active_processes", status.ActiveProcesses)
+	p.GaugeUpdate(k+"total_processes", status.TotalProcesses)
+	p.GaugeUpdate(k+"max_active_processes", status.MaxActiveProcesses)
+	// totals since pool start, reported as count and rate
+	p.totals.incTotal(p.BaseStat, k+"accepted_conn", float64(status.AcceptedConn))
+	p.totals.incTotal(p.BaseStat, k+"max_children_reached", float64(status.MaxChildrenReached))
+	p.totals.incTotal(p.BaseStat, k+"slow_requests", float64(status.SlowRequests))
+
+	// Idle, Running, Reading headers, Finishing ...
+	counts := make(map[string]int64)
+	for state := range s.states {
+		counts[state] = 0
+	}
+	for _, proc := range status.Processes {
+		state := util.SanitizeKey(proc.State)
+		counts[state]++
+		s.states[state] = true
+	}
+	for state, v := range counts {
+		p.GaugeUpdate(k+"processes.state."+state, v)
+	}
+}
+
+func (p *Phpfpm) readHTTP(addr string) ([]byte, error) {
+	resp, err := p.client.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (p *Phpfpm) readFcgi(s *phpfpmServer) ([]byte, error) {
+	code, body, err := fcgiGet(s.network, s.address, s.path, phpfpmQuery)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		// 404 'File not found.' if status path is wrong
+		return nil, fmt.Errorf("response %d: %s", code, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPgStatementTimeout(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{
+			"postgres://postgres@127.0.0.1:5432/postgres?sslmode=disable",
+			"postgres://postgres@127.0.0.1:5432/postgres?sslmode=disable&statement_timeout=5000",
+		},
+		{
+			"postgresql://127.0.0.1/app",
+			"postgresql://127.0.0.1/app?statement_timeout=5000",
+		},
+		// the option wins over the one in dsn
+		{
+			"postgres://127.0.0.1/app?statement_timeout=60000",
+			"postgres://127.0.0.1/app?statement_timeout=5000",
+		},
+		{
+			"host=127.0.0.1 port=5432 user=postgres sslmode=disable ",
+			"host=127.0.0.1 port=5432 user=postgres sslmode=disable statement_timeout=5000",
+		},
+	}
+	for _, tt := range tests {
+		if got := pgStatementTimeout(tt.dsn, 5*time.Second); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.dsn, got, tt.want)
+		}
+	}
+}
+
+func TestPgServerAddr(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"postgres://postgres@10.0.0.1:5433/postgres", "10.0.0.1:5433"},
+		{"postgresql://10.0.0.1/app", "10.0.0.1:5432"},
+		{"postgres:///app?host=/var/run/postgresql", "localhost:5432"},
+		{"host=10.0.0.1 port='5433' user=postgres", "10.0.0.1:5433"},
+		{"user=postgres", "localhost:5432"},
+	}
+	for _, tt := range tests {
+		if got := pgServerAddr(tt.dsn); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.dsn, got, tt.want)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 type ProcConfig struct {
 	Enable     bool    `toml:"enable"`
 	ProcNames  string  `toml:"process_names"`
+	TagsConfig
 }
 
 func NewProcCollector(registry metrics.Registry, conf ProcConfig) *ProcCollector {
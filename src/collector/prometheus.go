@@ -0,0 +1,368 @@
+/*
+scrape metrics of services exposed in prometheus text format:
+
+	$ curl http://localhost:9100/metrics
+	# HELP http_requests_total The total number of HTTP requests.
+	# TYPE http_requests_total counter
+	http_requests_total{method="post",code="200"} 1027
+	# TYPE http_request_duration_seconds histogram
+	http_request_duration_seconds_bucket{le="0.05"} 24054
+	http_request_duration_seconds_bucket{le="+Inf"} 144320
+	http_request_duration_seconds_sum 53423
+	http_request_duration_seconds_count 144320
+
+detail in https://prometheus.io/docs/instrumenting/exposition_formats/
+
+counters, buckets and _sum/_count of histograms and summaries are reported as count and rate,
+gauges, untyped and quantiles of summaries as gauge.
+
+labels listed in path_labels become path segments by their values, in order,
+labels in drop_labels are ignored, other labels are kept as tags, metric key like:
+
+	prometheus.app.http_requests_total.post.code=200
+	prometheus.app.http_request_duration_seconds.bucket.0_05
+	prometheus.app.rpc_duration_seconds.quantile.0_99
+*/
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// DefaultPrometheusMaxSeries limit series reported of one target, in case of
+// a service expose labels with unbounded values like user id
+const DefaultPrometheusMaxSeries = 1000
+
+type PrometheusConfig struct {
+	Enable  bool               `toml:"enable"`
+	Targets []PrometheusTarget `toml:"target"`
+	// seconds between scrapes, every collect if 0
+	IntervalSec int `toml:"interval_sec"`
+	HTTPConfig
+	TagsConfig
+}
+
+// PrometheusTarget is one url to scrape
+type PrometheusTarget struct {
+	// first segment of metric key, host and path of url if empty
+	Name string `toml:"name"`
+	Url  string `toml:"url"`
+	// glob patterns of metric names like "http_*", all metrics are allowed if empty,
+	// exclude is applied after include
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+	// labels which values become path segments, in order
+	PathLabels []string `toml:"path_labels"`
+	// labels ignored
+	DropLabels []string `toml:"drop_labels"`
+	// max series reported each scrape, default DefaultPrometheusMaxSeries
+	MaxSeries int `toml:"max_series"`
+}
+
+// promSample is one line of exposition format
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+func NewPrometheus(registry metrics.Registry, conf PrometheusConfig) (*Prometheus, error) {
+	bc := metrics.NewBaseStat("prometheus", registry)
+	p := &Prometheus{
+		BaseStat: bc,
+		Targets:  make([]PrometheusTarget, 0, len(conf.Targets)),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	var err error
+	p.client, err = newHTTPClient(conf.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range conf.Targets {
+		if t.Name == "" {
+			u, err := url.Parse(t.Url)
+			if err != nil {
+				p.OnErr("error_config", fmt.Errorf("error parse %s: %s", t.Url, err))
+				continue
+			}
+			t.Name = u.Host + u.Path
+		}
+		t.Name = util.SanitizeKey(t.Name)
+		if t.MaxSeries <= 0 {
+			t.MaxSeries = DefaultPrometheusMaxSeries
+		}
+		p.Targets = append(p.Targets, t)
+	}
+	return p, nil
+}
+
+// Prometheus scrape one or more prometheus targets
+type Prometheus struct {
+	*metrics.BaseStat
+	Targets  []PrometheusTarget
+	client   *httpClient
+	totals   floatCounters
+	interval time.Duration
+	run      backgroundRun
+}
+
+func (p *Prometheus) GetPrefix() string {
+	return p.Prefix
+}
+
+// Collect scrape targets in background, a slow target don't delay other collectors
+func (p *Prometheus) Collect() {
+	p.run.start(p.interval, p.collectAll)
+}
+
+// collectAll scrape targets concurrently
+func (p *Prometheus) collectAll() {
+	var wg sync.WaitGroup
+	for _, t := range p.Targets {
+		wg.Add(1)
+		go func(t PrometheusTarget) {
+			defer wg.Done()
+			p.collectTarget(t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (p *Prometheus) collectTarget(t PrometheusTarget) {
+	req, err := p.client.NewRequest("GET", t.Url, nil)
+	if err != nil {
+		p.OnErr("error_config", fmt.Errorf("error request %s: %s", t.Url, err))
+		return
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.GaugeUpdate(t.Name+".up", 0)
+		p.OnErr("error_making_request",
+			fmt.Errorf("error making HTTP request to %s: %s", t.Url, err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		p.GaugeUpdate(t.Name+".up", 0)
+		p.OnErr("error_response", fmt.Errorf("response from %s : %s", t.Url, resp.Status))
+		return
+	}
+
+	types, samples, err := parsePromText(resp.Body)
+	if err != nil {
+		p.GaugeUpdate(t.Name+".up", 0)
+		p.OnErr("error_parse", fmt.Errorf("error parse response from %s: %s", t.Url, err))
+		return
+	}
+	p.GaugeUpdate(t.Name+".up", 1)
+
+	series := 0
+	for _, s := range samples {
+		family, suffix := promFamily(types, s.name)
+		// _created is timestamp of counter creation in openmetrics, useless as metric
+		if suffix == "_created" || !t.allowed(family) {
+			continue
+		}
+		if series >= t.MaxSeries {
+			p.OnErr("error_series_limit",
+				fmt.Errorf("series of %s over limit %d, the rest are dropped", t.Url, t.MaxSeries))
+			break
+		}
+		series++
+
+		typ := types[family]
+		key := t.Name + "." + util.SanitizeKey(family)
+		counter := typ == "counter"
+		switch {
+		case typ == "histogram" && suffix == "_bucket":
+			key += ".bucket." + util.SanitizeKey(s.labels["le"])
+			delete(s.labels, "le")
+			counter = true
+		case typ == "summary" && suffix == "":
+			key += ".quantile." + util.SanitizeKey(s.labels["quantile"])
+			delete(s.labels, "quantile")
+		case (typ == "histogram" || typ == "summary") && suffix != "":
+			key += "." + strings.TrimPrefix(suffix, "_")
+			counter = true
+		}
+		key = t.labelKey(key, s.labels)
+
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		if counter {
+			p.totals.incTotal(p.BaseStat, key, s.value)
+		} else {
+			p.GaugeFloat64Update(key, s.value)
+		}
+	}
+	p.GaugeUpdate(t.Name+".series", series)
+}
+
+// allowed test metric name by include and exclude patterns
+func (t PrometheusTarget) allowed(name string) bool {
+	if len(t.Include) > 0 && !promMatch(t.Include, name) {
+		return false
+	}
+	return !promMatch(t.Exclude, name)
+}
+
+func promMatch(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// labelKey flatten labels to metric key by path_labels and drop_labels
+func (t PrometheusTarget) labelKey(key string, labels map[string]string) string {
+	for _, l := range t.PathLabels {
+		if v, ok := labels[l]; ok {
+			key += "." + util.SanitizeKey(v)
+			delete(labels, l)
+		}
+	}
+	for _, l := range t.DropLabels {
+		delete(labels, l)
+	}
+	if len(labels) == 0 {
+		return key
+	}
+	tags := make(map[string]string, len(labels))
+	for k, v := range labels {
+		tags[util.SanitizeKey(k)] = util.SanitizeKey(v)
+	}
+	return metrics.MakeMetric(key, tags)
+}
+
+// promFamily find metric family of a sample by '# TYPE' lines,
+// return family name and suffix like _bucket, _sum, _count, _total
+func promFamily(types map[string]string, name string) (string, string) {
+	if _, ok := types[name]; ok {
+		return name, ""
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total", "_created"} {
+		if strings.HasSuffix(name, suffix) {
+			family := strings.TrimSuffix(name, suffix)
+			if _, ok := types[family]; ok {
+				return family, suffix
+			}
+		}
+	}
+	return name, ""
+}
+
+// parsePromText parse text exposition format, return type of metric families
+// and samples, timestamps of samples are ignored.
+func parsePromText(r io.Reader) (map[string]string, []promSample, error) {
+	types := make(map[string]string)
+	samples := make([]promSample, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			// # TYPE <name> <type>, other comments and # HELP are ignored
+			fields := strings.Fields(line)
+			if len(fields) == 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		s, err := parsePromSample(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		samples = append(samples, s)
+	}
+	return types, samples, scanner.Err()
+}
+
+// parsePromSample parse line like: name{label="value",...} value [timestamp]
+func parsePromSample(line string) (promSample, error) {
+	s := promSample{labels: make(map[string]string)}
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return s, fmt.Errorf("bad line: %s", line)
+	}
+	s.name = line[:i]
+	rest := line[i:]
+	if rest[0] == '{' {
+		n, err := parsePromLabels(rest[1:], s.labels)
+		if err != nil {
+			return s, fmt.Errorf("%s: %s", err, line)
+		}
+		rest = rest[1+n:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("no value: %s", line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("bad value: %s", line)
+	}
+	s.value = v
+	return s, nil
+}
+
+// parsePromLabels parse labels after '{' to m, return bytes consumed including '}'
+func parsePromLabels(str string, m map[string]string) (int, error) {
+	i := 0
+	for {
+		for i < len(str) && (str[i] == ' ' || str[i] == ',') {
+			i++
+		}
+		if i >= len(str) {
+			return 0, fmt.Errorf("labels not closed")
+		}
+		if str[i] == '}' {
+			return i + 1, nil
+		}
+		eq := strings.IndexByte(str[i:], '=')
+		if eq < 0 || i+eq+1 >= len(str) || str[i+eq+1] != '"' {
+			return 0, fmt.Errorf("bad label")
+		}
+		name := strings.TrimSpace(str[i : i+eq])
+		i += eq + 2
+
+		var value strings.Builder
+		for ; i < len(str) && str[i] != '"'; i++ {
+			c := str[i]
+			if c == '\\' && i+1 < len(str) {
+				i++
+				switch str[i] {
+				case 'n':
+					c = '\n'
+				default:
+					c = str[i]
+				}
+			}
+			value.WriteByte(c)
+		}
+		if i >= len(str) {
+			return 0, fmt.Errorf("label value not closed")
+		}
+		i++
+		m[name] = value.String()
+	}
+}
@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePromLabels(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    map[string]string
+		wantN   int
+		wantErr bool
+	}{
+		{`} 1`, map[string]string{}, 1, false},
+		{`method="get"} 1`, map[string]string{"method": "get"}, 13, false},
+		{`method="get",code="200"} 1`, map[string]string{"method": "get", "code": "200"}, 24, false},
+		// trailing comma and spaces are allowed
+		{`method="get", code="200",} 1`, map[string]string{"method": "get", "code": "200"}, 26, false},
+		{`path="/a\"b\\c\nd"} 1`, map[string]string{"path": "/a\"b\\c\nd"}, 19, false},
+		{`le="+Inf"} 1`, map[string]string{"le": "+Inf"}, 10, false},
+		{`msg="a}b,c=d"} 1`, map[string]string{"msg": "a}b,c=d"}, 14, false},
+		{`method="get"`, nil, 0, true},
+		{`method=get} 1`, nil, 0, true},
+		{`method="get} 1`, nil, 0, true},
+		{`method} 1`, nil, 0, true},
+	}
+	for _, tt := range tests {
+		got := make(map[string]string)
+		n, err := parsePromLabels(tt.str, got)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error, got %v", tt.str, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.str, err)
+			continue
+		}
+		if n != tt.wantN || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %d %v, want %d %v", tt.str, n, got, tt.wantN, tt.want)
+		}
+	}
+}
+
+func TestParsePromText(t *testing.T) {
+	text := `
+# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="200"} 3.5
+
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.1"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} NaN
+# a comment
+go_goroutines 42
+`
+	types, samples, err := parsePromText(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := map[string]string{
+		"http_requests_total":           "counter",
+		"http_request_duration_seconds": "histogram",
+		"rpc_duration_seconds":          "summary",
+	}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("got types %v, want %v", types, wantTypes)
+	}
+
+	want := []promSample{
+		{"http_requests_total", map[string]string{"method": "get", "code": "200"}, 1027},
+		{"http_requests_total", map[string]string{"method": "post", "code": "200"}, 3.5},
+		{"http_request_duration_seconds_bucket", map[string]string{"le": "0.1"}, 24054},
+		{"http_request_duration_seconds_bucket", map[string]string{"le": "+Inf"}, 144320},
+		{"http_request_duration_seconds_sum", map[string]string{}, 53423},
+		{"http_request_duration_seconds_count", map[string]string{}, 144320},
+		{"rpc_duration_seconds", map[string]string{"quantile": "0.5"}, math.NaN()},
+		{"go_goroutines", map[string]string{}, 42},
+	}
+	if len(samples) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(samples), len(want))
+	}
+	for i, s := range samples {
+		w := want[i]
+		sameValue := s.value == w.value || math.IsNaN(s.value) && math.IsNaN(w.value)
+		if s.name != w.name || !reflect.DeepEqual(s.labels, w.labels) || !sameValue {
+			t.Errorf("sample %d: got %v, want %v", i, s, w)
+		}
+	}
+}
+
+func TestParsePromTextError(t *testing.T) {
+	for _, text := range []string{
+		"go_goroutines",
+		"go_goroutines abc",
+		`http_requests_total{method="get" 1`,
+		"{method=\"get\"} 1",
+	} {
+		if _, _, err := parsePromText(strings.NewReader(text)); err == nil {
+			t.Errorf("%s: want error", text)
+		}
+	}
+}
+
+func TestPromFamily(t *testing.T) {
+	types := map[string]string{
+		"http_requests_total":           "counter",
+		"http_request_duration_seconds": "histogram",
+		"process_cpu_seconds":           "counter",
+	}
+	tests := []struct {
+		name, family, suffix string
+	}{
+		{"http_requests_total", "http_requests_total", ""},
+		{"http_request_duration_seconds_bucket", "http_request_duration_seconds", "_bucket"},
+		{"http_request_duration_seconds_count", "http_request_duration_seconds", "_count"},
+		// counters of openmetrics are named without _total in TYPE
+		{"process_cpu_seconds_total", "process_cpu_seconds", "_total"},
+		{"process_cpu_seconds_created", "process_cpu_seconds", "_created"},
+		{"go_goroutines", "go_goroutines", ""},
+		{"unknown_sum", "unknown_sum", ""},
+	}
+	for _, tt := range tests {
+		family, suffix := promFamily(types, tt.name)
+		if family != tt.family || suffix != tt.suffix {
+			t.Errorf("%s: got %s %s, want %s %s", tt.name, family, suffix, tt.family, tt.suffix)
+		}
+	}
+}
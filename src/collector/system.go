@@ -177,7 +177,7 @@ func (s *SysCollector) collectSysCPU() {
 
 		if totalDelta < 0 {
 			s.cpu.lastStats = times
-			s.OnErr("ErrorCollectSysCPU1", fmt.Errorf("CPU time current: %s less previous: %s", total, lastTotal))
+			s.OnErr("ErrorCollectSysCPU1", fmt.Errorf("CPU time current: %f less previous: %f", total, lastTotal))
 		}
 
 		if totalDelta == 0 {
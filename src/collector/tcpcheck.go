@@ -0,0 +1,167 @@
+/*
+check tcp ports can be connected, like:
+
+	$ nc -zv 127.0.0.1 6379
+
+a string can be sent after connected and the response must contain expect, like
+"PING\r\n" and "+PONG" for redis, or only expect for servers sending banner first,
+like "SSH-" for sshd, "220" for smtp.
+
+metric key like: tcp_check.127_0_0_1_6379.connect_ms
+*/
+package collector
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// seconds between checks if not set
+const DefaultTcpCheckIntervalSec = 10
+
+type TcpCheckConfig struct {
+	Enable bool             `toml:"enable"`
+	Checks []TcpCheckTarget `toml:"check"`
+	// seconds between checks, not every collect as a check may take seconds
+	IntervalSec int `toml:"interval_sec"`
+	TagsConfig
+}
+
+// TcpCheckTarget is one or more host:port checked the same way
+type TcpCheckTarget struct {
+	// host:port list, each one named by itself
+	Addresses []string `toml:"addresses"`
+	// sent after connected, escapes like \r\n are written in toml string
+	Send string `toml:"send"`
+	// response must contain it if set
+	Expect     string `toml:"expect"`
+	TimeoutSec int    `toml:"timeout_sec"`
+}
+
+type tcpCheckTarget struct {
+	TcpCheckTarget
+	name    string
+	address string
+}
+
+func NewTcpCheck(registry metrics.Registry, conf TcpCheckConfig) *TcpCheck {
+	bc := metrics.NewBaseStat("tcp_check", registry)
+	t := &TcpCheck{
+		BaseStat: bc,
+		checks:   make([]*tcpCheckTarget, 0),
+		interval: time.Duration(conf.IntervalSec) * time.Second,
+	}
+	if conf.IntervalSec <= 0 {
+		t.interval = DefaultTcpCheckIntervalSec * time.Second
+	}
+	for _, c := range conf.Checks {
+		if c.TimeoutSec <= 0 {
+			c.TimeoutSec = RequestMadeTimeOutSec
+		}
+		for _, addr := range c.Addresses {
+			addr = strings.TrimSpace(addr)
+			t.checks = append(t.checks, &tcpCheckTarget{
+				TcpCheckTarget: c,
+				name:           util.SanitizeKey(addr),
+				address:        addr,
+			})
+		}
+	}
+	return t
+}
+
+// TcpCheck check one or more tcp ports
+type TcpCheck struct {
+	*metrics.BaseStat
+	checks   []*tcpCheckTarget
+	interval time.Duration
+	run      backgroundRun
+}
+
+func (t *TcpCheck) GetPrefix() string {
+	return t.Prefix
+}
+
+// Collect start checks every interval in background, so a port not answering
+// don't delay other collectors until timeout.
+func (t *TcpCheck) Collect() {
+	t.run.start(t.interval, t.checkAll)
+}
+
+// checkAll run checks concurrently
+func (t *TcpCheck) checkAll() {
+	var wg sync.WaitGroup
+	for _, c := range t.checks {
+		wg.Add(1)
+		go func(c *tcpCheckTarget) {
+			defer wg.Done()
+			t.check(c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (t *TcpCheck) check(c *tcpCheckTarget) {
+	timeout := time.Duration(c.TimeoutSec) * time.Second
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", c.address, timeout)
+	if err != nil {
+		t.GaugeUpdate(c.name+".up", 0)
+		t.OnErr("error_connect", fmt.Errorf("error connect %s: %s", c.address, err))
+		return
+	}
+	defer conn.Close()
+	t.GaugeFloat64Update(c.name+".connect_ms", durationMs(time.Since(start)))
+
+	if c.Send == "" && c.Expect == "" {
+		t.GaugeUpdate(c.name+".up", 1)
+		return
+	}
+
+	start = time.Now()
+	conn.SetDeadline(start.Add(timeout))
+	if c.Send != "" {
+		if _, err := conn.Write([]byte(c.Send)); err != nil {
+			t.GaugeUpdate(c.name+".up", 0)
+			t.OnErr("error_send", fmt.Errorf("error send to %s: %s", c.address, err))
+			return
+		}
+	}
+	if c.Expect == "" {
+		t.GaugeUpdate(c.name+".up", 1)
+		return
+	}
+
+	match, err := tcpExpect(conn, c.Expect)
+	if err != nil {
+		t.OnErr("error_read", fmt.Errorf("error read from %s: %s", c.address, err))
+	}
+	if match {
+		t.GaugeFloat64Update(c.name+".response_ms", durationMs(time.Since(start)))
+	}
+	t.GaugeUpdate(c.name+".expect_match", boolToInt(match))
+	t.GaugeUpdate(c.name+".up", boolToInt(match))
+}
+
+// tcpExpect read until expect is found, or error like deadline exceeded and eof
+func tcpExpect(conn net.Conn, expect string) (bool, error) {
+	buf := make([]byte, 4096)
+	var got []byte
+	for len(got) < 64*1024 {
+		n, err := conn.Read(buf)
+		got = append(got, buf[:n]...)
+		if strings.Contains(string(got), expect) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, fmt.Errorf("%s not found in response", expect)
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// a port accepting but not answering don't block Collect until timeout
+func TestTcpCheckCollect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().String()
+	r := metrics.NewRegistry()
+	c := NewTcpCheck(r, TcpCheckConfig{Checks: []TcpCheckTarget{{
+		Addresses:  []string{addr},
+		Send:       "PING\r\n",
+		Expect:     "+PONG",
+		TimeoutSec: 1,
+	}}})
+
+	start := time.Now()
+	c.Collect()
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Collect took %s", d)
+	}
+
+	key := "tcp_check." + util.SanitizeKey(addr) + ".up"
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if g, ok := r.Get(key).(metrics.Gauge); ok {
+			if g.Value() != 0 {
+				t.Errorf("got up %d, want 0", g.Value())
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s not reported", key)
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/BurntSushi/toml"
 	statsd "github.com/coder-van/v-stats"
 	"github.com/coder-van/v-collect/src/collector"
+	"github.com/coder-van/v-collect/src/output"
+	"github.com/coder-van/v-collect/src/util"
 )
 
 func NewConfig() *Config {
@@ -24,15 +26,21 @@ type Config struct {
 	AgentDefaultName string        `toml:"agent_name"`
 	CollectSeconds   int           `toml:"collect_seconds"`
 	HostConfig       HostConfig    `toml:"host"`
+	// tags of all metrics, see util/tags.go
+	Tags             map[string]string `toml:"tags"`
 	LoggingConfig    LoggingConfig `toml:"logging"`
 	StatsdConfig     statsd.Config   `toml:"statsd"`
 	CollectorConf    CollectorConfig `toml:"collector"`
+	OutputConf       OutputConfig    `toml:"output"`
 }
 
 type HostConfig struct {
-	HostGroup  string `toml:"host_group"`
-	LicenseKey string `toml:"license_key"`
-	HostSign   string `toml:"host_sign"`
+	HostGroup   string `toml:"host_group"`
+	LicenseKey  string `toml:"license_key"`
+	HostSign    string `toml:"host_sign"`
+	// register host to get host_sign if it's empty, see register.go
+	RegisterUrl string `toml:"register_url"`
+	StateFile   string `toml:"state_file"`
 }
 
 type LoggingConfig struct {
@@ -41,8 +49,36 @@ type LoggingConfig struct {
 }
 
 type CollectorConfig struct {
-	ProcConf  collector.ProcConfig  `toml:"proc"`
-	NginxConf collector.NginxConfig `toml:"nginx"`
+	ProcConf      collector.ProcConfig       `toml:"proc"`
+	NginxConf     collector.NginxConfig      `toml:"nginx"`
+	MysqlConf     collector.MysqlConfig      `toml:"mysql"`
+	MongoConf     collector.MongodbConfig    `toml:"mongodb"`
+	PostgresConf  collector.PostgresqlConfig `toml:"postgresql"`
+	MemcacheConf  collector.MemcachedConfig  `toml:"memcached"`
+	ApacheConf    collector.ApacheConfig     `toml:"apache"`
+	HaproxyConf   collector.HaproxyConfig    `toml:"haproxy"`
+	PhpfpmConf    collector.PhpfpmConfig     `toml:"phpfpm"`
+	PromConf      collector.PrometheusConfig `toml:"prometheus"`
+	HttpjsonConf  collector.HttpjsonConfig   `toml:"httpjson"`
+	ExecConf      collector.ExecConfig       `toml:"exec"`
+	HttpCheckConf collector.HttpCheckConfig  `toml:"http_check"`
+	TcpCheckConf  collector.TcpCheckConfig   `toml:"tcp_check"`
+	DnsCheckConf  collector.DnsCheckConfig   `toml:"dns_check"`
+	CertConf      collector.CertConfig       `toml:"cert"`
+	FilestatConf  collector.FilestatConfig   `toml:"filestat"`
+}
+
+type OutputConfig struct {
+	SpoolConf       output.SpoolConfig        `toml:"spool"`
+	GraphiteConfs   []output.GraphiteConfig   `toml:"graphite"`
+	PrometheusConfs []output.PrometheusConfig `toml:"prometheus"`
+	InfluxConfs     []output.InfluxConfig     `toml:"influxdb"`
+	OpenTSDBConfs   []output.OpenTSDBConfig   `toml:"opentsdb"`
+	PushConfs       []output.PushConfig       `toml:"push"`
+	CarbonConfs     []output.CarbonConfig     `toml:"carbon"`
+	FileConfs       []output.FileConfig       `toml:"file"`
+	StdoutConfs     []output.StdoutConfig     `toml:"stdout"`
+	OTLPConfs       []output.OTLPConfig       `toml:"otlp"`
 }
 
 func LoadConfig(confPath string) (*Config, error) {
@@ -59,6 +95,12 @@ func LoadConfig(confPath string) (*Config, error) {
 	if _, err := toml.DecodeFile(cp, c); err != nil {
 		return nil, err
 	}
+	if err := util.CheckTags(c.Tags); err != nil {
+		return nil, fmt.Errorf("[tags]: %s", err)
+	}
+	if err := loadHostIdentity(c); err != nil {
+		return nil, err
+	}
 	SetDefault(c)
 	return c, nil
 }
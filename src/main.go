@@ -8,8 +8,11 @@ import (
 	"time"
 	
 	"github.com/coder-van/v-collect/src/collector"
+	"github.com/coder-van/v-collect/src/output"
+	"github.com/coder-van/v-collect/src/util"
 	"github.com/coder-van/v-stats"
 	"github.com/coder-van/v-stats/metrics"
+	"github.com/coder-van/v-stats/receivers"
 	"github.com/coder-van/v-util/log"
 )
 
@@ -20,7 +23,6 @@ func NewAgent() *agent {
 	if err != nil {
 		fmt.Println(err)
 		panic("Load config failed")
-		return nil
 	}
 	if conf.Debug {
 		log.Debug = true
@@ -29,33 +31,260 @@ func NewAgent() *agent {
 	log.SetLogDir(conf.LoggingConfig.LogDir)
 
 	prefix := fmt.Sprintf("%s.%s.", conf.HostConfig.HostGroup, conf.HostConfig.HostSign)
-	r := metrics.NewPrefixedChildRegistry(metrics.GlobRegistry, prefix)
+	// global tags are merged into names registered, statsd metrics received included
+	r := util.NewTaggedRegistry(metrics.NewPrefixedChildRegistry(metrics.GlobRegistry, prefix), conf.Tags)
 
-	sd := statsd.NewStatsD(&conf.StatsdConfig)
-	sd.SetRegistry(r)
+	sc := &conf.StatsdConfig
+	sc.Check()
+	packetCh := make(chan []byte, sc.ReceiverQueueSize)
+	pointCh := make(chan metrics.MetricDataPoint, sc.DataPointQueueSize)
+
+	om := output.NewManager(output.ManagerConfig{
+		FlushSeconds: sc.BackendFlushSeconds,
+		BatchSize:    sc.BackendFlushSize,
+		HostGroup:    conf.HostConfig.HostGroup,
+		HostSign:     conf.HostConfig.HostSign,
+		Tags:         conf.Tags,
+		Spool:        conf.OutputConf.SpoolConf,
+	}, metrics.GlobRegistry, pointCh)
+	registerOutputs(om, conf)
 
 	a := &agent{
-		sd: sd,
 		cm: collector.NewCollectorManager(conf.CollectSeconds, 1, r),
+		om: om,
+		// aggregator first, receiver last, they are stopped in reverse order
+		services: []service{statsd.NewAggregator(sc.FlushSeconds, r, packetCh, pointCh)},
+	}
+	if !sc.IsLocal {
+		a.services = append(a.services, receivers.NewUdpReceiver(sc.ReceiverAddr, packetCh))
 	}
 	
 	sysCollector := collector.NewSysCollector(r)
 	a.cm.RegisterCollector(sysCollector)
 	
 	if conf.CollectorConf.NginxConf.Enable {
-		nginxCollector := collector.NewNginx(r, conf.CollectorConf.NginxConf)
-		a.cm.RegisterCollectors(nginxCollector)
+		nginxCollector, err := collector.NewNginx(collectorRegistry(r, "nginx", conf.CollectorConf.NginxConf.Tags), conf.CollectorConf.NginxConf)
+		if err != nil {
+			fmt.Println("nginx collector not registered:", err)
+		} else {
+			a.cm.RegisterCollectors(nginxCollector)
+		}
+	}
+	if conf.CollectorConf.ApacheConf.Enable {
+		apacheCollector, err := collector.NewApache(collectorRegistry(r, "apache", conf.CollectorConf.ApacheConf.Tags), conf.CollectorConf.ApacheConf)
+		if err != nil {
+			fmt.Println("apache collector not registered:", err)
+		} else {
+			a.cm.RegisterCollector(apacheCollector)
+		}
+	}
+	if conf.CollectorConf.HaproxyConf.Enable {
+		haproxyCollector, err := collector.NewHaproxy(collectorRegistry(r, "haproxy", conf.CollectorConf.HaproxyConf.Tags), conf.CollectorConf.HaproxyConf)
+		if err != nil {
+			fmt.Println("haproxy collector not registered:", err)
+		} else {
+			a.cm.RegisterCollector(haproxyCollector)
+		}
+	}
+	if conf.CollectorConf.PhpfpmConf.Enable {
+		phpfpmCollector, err := collector.NewPhpfpm(collectorRegistry(r, "phpfpm", conf.CollectorConf.PhpfpmConf.Tags), conf.CollectorConf.PhpfpmConf)
+		if err != nil {
+			fmt.Println("phpfpm collector not registered:", err)
+		} else {
+			a.cm.RegisterCollector(phpfpmCollector)
+		}
+	}
+	if conf.CollectorConf.PromConf.Enable {
+		promCollector, err := collector.NewPrometheus(collectorRegistry(r, "prometheus", conf.CollectorConf.PromConf.Tags), conf.CollectorConf.PromConf)
+		if err != nil {
+			fmt.Println("prometheus collector not registered:", err)
+		} else {
+			a.cm.RegisterCollector(promCollector)
+		}
+	}
+	if conf.CollectorConf.HttpjsonConf.Enable {
+		httpjsonCollector, err := collector.NewHttpjson(collectorRegistry(r, "httpjson", conf.CollectorConf.HttpjsonConf.Tags), conf.CollectorConf.HttpjsonConf)
+		if err != nil {
+			fmt.Println("httpjson collector not registered:", err)
+		} else {
+			a.cm.RegisterCollector(httpjsonCollector)
+		}
+	}
+	if conf.CollectorConf.ExecConf.Enable {
+		execCollector := collector.NewExec(collectorRegistry(r, "exec", conf.CollectorConf.ExecConf.Tags), conf.CollectorConf.ExecConf)
+		a.cm.RegisterCollector(execCollector)
+	}
+	if conf.CollectorConf.HttpCheckConf.Enable {
+		httpCheckCollector := collector.NewHttpCheck(collectorRegistry(r, "http_check", conf.CollectorConf.HttpCheckConf.Tags), conf.CollectorConf.HttpCheckConf)
+		a.cm.RegisterCollector(httpCheckCollector)
+	}
+	if conf.CollectorConf.TcpCheckConf.Enable {
+		tcpCheckCollector := collector.NewTcpCheck(collectorRegistry(r, "tcp_check", conf.CollectorConf.TcpCheckConf.Tags), conf.CollectorConf.TcpCheckConf)
+		a.cm.RegisterCollector(tcpCheckCollector)
+	}
+	if conf.CollectorConf.DnsCheckConf.Enable {
+		dnsCheckCollector := collector.NewDnsCheck(collectorRegistry(r, "dns_check", conf.CollectorConf.DnsCheckConf.Tags), conf.CollectorConf.DnsCheckConf)
+		a.cm.RegisterCollector(dnsCheckCollector)
+	}
+	if conf.CollectorConf.CertConf.Enable {
+		certCollector := collector.NewCert(collectorRegistry(r, "cert", conf.CollectorConf.CertConf.Tags), conf.CollectorConf.CertConf)
+		a.cm.RegisterCollector(certCollector)
+	}
+	if conf.CollectorConf.FilestatConf.Enable {
+		filestatCollector := collector.NewFilestat(collectorRegistry(r, "filestat", conf.CollectorConf.FilestatConf.Tags), conf.CollectorConf.FilestatConf)
+		a.cm.RegisterCollector(filestatCollector)
 	}
 	if conf.CollectorConf.ProcConf.Enable {
-		procCollector := collector.NewProcCollector(r, conf.CollectorConf.ProcConf)
+		procCollector := collector.NewProcCollector(collectorRegistry(r, "proc", conf.CollectorConf.ProcConf.Tags), conf.CollectorConf.ProcConf)
 		a.cm.RegisterCollector(procCollector)
 	}
+	if conf.CollectorConf.MysqlConf.Enable {
+		mysqlCollector := collector.NewMysql(collectorRegistry(r, "mysql", conf.CollectorConf.MysqlConf.Tags), conf.CollectorConf.MysqlConf)
+		a.cm.RegisterCollector(mysqlCollector)
+	}
+	if conf.CollectorConf.MongoConf.Enable {
+		mongoCollector := collector.NewMongodb(collectorRegistry(r, "mongodb", conf.CollectorConf.MongoConf.Tags), conf.CollectorConf.MongoConf)
+		a.cm.RegisterCollector(mongoCollector)
+	}
+	if conf.CollectorConf.PostgresConf.Enable {
+		pgCollector := collector.NewPostgresql(collectorRegistry(r, "postgresql", conf.CollectorConf.PostgresConf.Tags), conf.CollectorConf.PostgresConf)
+		a.cm.RegisterCollector(pgCollector)
+	}
+	if conf.CollectorConf.MemcacheConf.Enable {
+		memcachedCollector := collector.NewMemcached(collectorRegistry(r, "memcached", conf.CollectorConf.MemcacheConf.Tags), conf.CollectorConf.MemcacheConf)
+		a.cm.RegisterCollector(memcachedCollector)
+	}
 	return a
 }
 
+// collectorRegistry return registry merging tags of a collector into its metrics
+func collectorRegistry(r metrics.Registry, name string, tags map[string]string) metrics.Registry {
+	if err := util.CheckTags(tags); err != nil {
+		fmt.Println(err)
+		panic("Invalid tags of collector " + name)
+	}
+	return util.NewTaggedRegistry(r, tags)
+}
+
+// registerOutputs register outputs enabled, graphite at graphite_addr of statsd is
+// registered if no output enabled
+func registerOutputs(om *output.Manager, conf *Config) {
+	oc := &conf.OutputConf
+	for _, c := range oc.GraphiteConfs {
+		if !c.Enable {
+			continue
+		}
+		if c.Address == "" {
+			c.Address = conf.StatsdConfig.GraphiteAddr
+		}
+		om.RegisterOutput(output.NewGraphite(c), c.RouteConfig)
+	}
+	for _, c := range oc.PrometheusConfs {
+		if c.Enable {
+			om.RegisterOutput(output.NewPrometheus(c), c.RouteConfig)
+		}
+	}
+	for _, c := range oc.InfluxConfs {
+		if c.Enable {
+			om.RegisterOutput(output.NewInflux(c), c.RouteConfig)
+		}
+	}
+	for _, c := range oc.OpenTSDBConfs {
+		if c.Enable {
+			om.RegisterOutput(output.NewOpenTSDB(c), c.RouteConfig)
+		}
+	}
+	for _, c := range oc.PushConfs {
+		if !c.Enable {
+			continue
+		}
+		if conf.HostConfig.LicenseKey == "" {
+			panic("license_key of host is required by push output")
+		}
+		push, err := output.NewPush(c, conf.AgentDefaultName,
+			conf.HostConfig.HostGroup, conf.HostConfig.HostSign, conf.HostConfig.LicenseKey)
+		if err != nil {
+			fmt.Println(err)
+			panic("Init push output failed")
+		}
+		om.RegisterOutput(push, c.RouteConfig)
+	}
+	for _, c := range oc.CarbonConfs {
+		if !c.Enable {
+			continue
+		}
+		carbon, err := output.NewCarbon(c)
+		if err != nil {
+			fmt.Println(err)
+			panic("Init carbon output failed")
+		}
+		om.RegisterOutput(carbon, c.RouteConfig)
+	}
+	for _, c := range oc.FileConfs {
+		if !c.Enable {
+			continue
+		}
+		file, err := output.NewFile(c)
+		if err != nil {
+			fmt.Println(err)
+			panic("Init file output failed")
+		}
+		om.RegisterOutput(file, c.RouteConfig)
+	}
+	for _, c := range oc.StdoutConfs {
+		if !c.Enable {
+			continue
+		}
+		stdout, err := output.NewStdout(c)
+		if err != nil {
+			fmt.Println(err)
+			panic("Init stdout output failed")
+		}
+		om.RegisterOutput(stdout, c.RouteConfig)
+	}
+	for _, c := range oc.OTLPConfs {
+		if !c.Enable {
+			continue
+		}
+		hostname, _ := os.Hostname()
+		otlp, err := output.NewOTLP(c, hostname, conf.HostConfig.HostGroup, conf.HostConfig.HostSign)
+		if err != nil {
+			fmt.Println(err)
+			panic("Init otlp output failed")
+		}
+		om.RegisterOutput(otlp, c.RouteConfig)
+	}
+	if om.Len() == 0 {
+		c := output.GraphiteConfig{Address: conf.StatsdConfig.GraphiteAddr}
+		om.RegisterOutput(output.NewGraphite(c), c.RouteConfig)
+	}
+}
+
+type service interface {
+	Start()
+	Stop()
+}
+
 type agent struct {
-	cm *collector.CollectorManager
-	sd *statsd.StatsD
+	cm       *collector.CollectorManager
+	om       *output.Manager
+	services []service
+}
+
+func (a *agent) start() {
+	a.om.Start()
+	for _, s := range a.services {
+		s.Start()
+	}
+	a.cm.Start()
+}
+
+func (a *agent) stop() {
+	a.cm.Stop()
+	for i := len(a.services) - 1; i >= 0; i-- {
+		a.services[i].Stop()
+	}
+	a.om.Stop()
 }
 
 func main() {
@@ -69,13 +298,11 @@ func main() {
 	go func() {
 		sig := <-signCh
 		fmt.Println(sig)
+		agent.stop()
 		exitCh <- true
-		agent.sd.StopAll()
-		agent.cm.Stop()
 	}()
 
-	agent.sd.StartAll()
-	agent.cm.Start()
+	agent.start()
 
 	<-exitCh
 	time.Sleep(time.Second)
@@ -0,0 +1,311 @@
+package output
+
+/*
+ write points to a pool of carbon-cache directly, each key is sent to nodes found by
+ consistent hashing, same as carbon-relay with RELAY_METHOD = consistent-hashing:
+
+	[[output.carbon]]
+	destinations = ["10.0.0.1:2003:a", "10.0.0.2:2003:b"]
+	hash_type = "carbon_ch"
+	replication_factor = 1
+
+ destinations are host:port:instance like DESTINATIONS of carbon-relay, the ring is built
+ from host and instance, port is not hashed. hash_type is carbon_ch (md5) or fnv1a_ch.
+
+ a node failed to write is marked down for down_seconds, points of it are rerouted to
+ the next node on the ring not written yet, counted by agent.output.<name>.rerouted.
+ only points failed on all nodes are returned as unsent.
+*/
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+const (
+	CarbonHashMD5  = "carbon_ch"
+	CarbonHashFNV1 = "fnv1a_ch"
+
+	// positions of a node on the ring, same as carbon
+	carbonRingReplicas = 100
+
+	DefaultCarbonDownSeconds = 30
+)
+
+type CarbonConfig struct {
+	Enable            bool     `toml:"enable"`
+	Destinations      []string `toml:"destinations"`
+	HashType          string   `toml:"hash_type"`
+	ReplicationFactor int      `toml:"replication_factor"`
+	// plain or tagged, see graphite.go
+	Format      string `toml:"format"`
+	DownSeconds int    `toml:"down_seconds"`
+	RouteConfig
+}
+
+type carbonNode struct {
+	*Graphite
+	server   string
+	instance string
+	// guarded by Carbon
+	downUntil time.Time
+}
+
+// ringKey is str() of (server, instance) tuple in carbon, replicas of a node are
+// positioned by it
+func (n *carbonNode) ringKey(hashType string, i int) string {
+	if hashType == CarbonHashFNV1 {
+		return fmt.Sprintf("%d-%s", i, n.instance)
+	}
+	instance := "None"
+	if n.instance != "" {
+		instance = "'" + n.instance + "'"
+	}
+	return fmt.Sprintf("('%s', %s):%d", n.server, instance, i)
+}
+
+type carbonRingEntry struct {
+	position int
+	node     int
+}
+
+// carbonRing is ConsistentHashRing of carbon
+type carbonRing struct {
+	hashType string
+	entries  []carbonRingEntry
+	nodes    int
+}
+
+func newCarbonRing(hashType string, nodes []*carbonNode) *carbonRing {
+	r := &carbonRing{hashType: hashType, nodes: len(nodes)}
+	used := make(map[int]bool)
+	for i, n := range nodes {
+		for j := 0; j < carbonRingReplicas; j++ {
+			pos := r.position(n.ringKey(hashType, j))
+			for used[pos] {
+				pos++
+			}
+			used[pos] = true
+			r.entries = append(r.entries, carbonRingEntry{position: pos, node: i})
+		}
+	}
+	sort.Slice(r.entries, func(i, j int) bool { return r.entries[i].position < r.entries[j].position })
+	return r
+}
+
+func (r *carbonRing) position(key string) int {
+	if r.hashType == CarbonHashFNV1 {
+		h := util.Hash(key)
+		return int((h >> 16) ^ (h & 0xffff))
+	}
+	sum := md5.Sum([]byte(key))
+	return int(binary.BigEndian.Uint16(sum[:2]))
+}
+
+// order return all nodes in ring order from position of key
+func (r *carbonRing) order(key string) []int {
+	pos := r.position(key)
+	index := sort.Search(len(r.entries), func(i int) bool { return r.entries[i].position >= pos })
+	result := make([]int, 0, r.nodes)
+	seen := make(map[int]bool, r.nodes)
+	for k := 0; k < len(r.entries) && len(result) < r.nodes; k++ {
+		n := r.entries[(index+k)%len(r.entries)].node
+		if !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// NewCarbon return error if destinations are invalid
+func NewCarbon(conf CarbonConfig) (*Carbon, error) {
+	if len(conf.Destinations) == 0 {
+		return nil, fmt.Errorf("no destinations of carbon")
+	}
+	if conf.HashType == "" {
+		conf.HashType = CarbonHashMD5
+	}
+	if conf.HashType != CarbonHashMD5 && conf.HashType != CarbonHashFNV1 {
+		return nil, fmt.Errorf("unknown hash_type %s", conf.HashType)
+	}
+	if conf.ReplicationFactor <= 0 {
+		conf.ReplicationFactor = 1
+	}
+	if conf.DownSeconds <= 0 {
+		conf.DownSeconds = DefaultCarbonDownSeconds
+	}
+	c := &Carbon{conf: conf}
+	for _, d := range conf.Destinations {
+		parts := strings.Split(d, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("bad destination %s, should be host:port:instance", d)
+		}
+		n := &carbonNode{
+			Graphite: NewGraphite(GraphiteConfig{Address: parts[0] + ":" + parts[1], Format: conf.Format}),
+			server:   parts[0],
+		}
+		if len(parts) == 3 {
+			n.instance = parts[2]
+		}
+		c.nodes = append(c.nodes, n)
+	}
+	if conf.ReplicationFactor > len(c.nodes) {
+		c.conf.ReplicationFactor = len(c.nodes)
+	}
+	c.ring = newCarbonRing(conf.HashType, c.nodes)
+	return c, nil
+}
+
+// Carbon shard points to carbon nodes
+type Carbon struct {
+	sync.Mutex
+	conf  CarbonConfig
+	nodes []*carbonNode
+	ring  *carbonRing
+	// self metrics, nil if not registered to manager
+	stat *metrics.BaseStat
+}
+
+func (c *Carbon) Name() string {
+	return "carbon:" + strings.Join(c.conf.Destinations, ",")
+}
+
+func (c *Carbon) setRegistry(registry metrics.Registry) {
+	c.stat = metrics.NewBaseStat("agent.output", registry)
+}
+
+func (c *Carbon) Write(points []*Point) error {
+	// nodes written or tried for each point
+	tried := make([]map[int]bool, len(points))
+	pending := make(map[int][]int)
+	for i, p := range points {
+		tried[i] = make(map[int]bool)
+		for _, n := range c.pick(p, tried[i], c.conf.ReplicationFactor) {
+			pending[n] = append(pending[n], i)
+		}
+	}
+
+	lost := make([]bool, len(points))
+	var rerouted, lostCount int
+	var lastErr error
+	for len(pending) > 0 {
+		failed := c.writeNodes(points, pending)
+		pending = make(map[int][]int)
+		for n, err := range failed {
+			lastErr = err
+			c.markDown(n)
+			for _, i := range err.indexes {
+				next := c.pick(points[i], tried[i], 1)
+				if len(next) == 0 {
+					if !lost[i] {
+						lost[i] = true
+						lostCount++
+					}
+					continue
+				}
+				rerouted++
+				pending[next[0]] = append(pending[next[0]], i)
+			}
+		}
+	}
+	if rerouted > 0 && c.stat != nil {
+		c.stat.CounterInc(util.SanitizeKey(c.Name())+".rerouted", rerouted)
+	}
+	if lostCount == 0 {
+		// every point is written, maybe by other nodes
+		return nil
+	}
+	err := fmt.Errorf("%d points lost, all nodes failed, last error: %s", lostCount, lastErr)
+	if lostCount == len(points) {
+		return err
+	}
+	unsent := make([]*Point, 0, lostCount)
+	for i, p := range points {
+		if lost[i] {
+			unsent = append(unsent, p)
+		}
+	}
+	return &PartialError{Err: err, Unsent: unsent}
+}
+
+// pick at most count nodes alive not tried in ring order of key, and mark them tried.
+// nodes down are tried if all nodes are down, so a recovered pool is found at once.
+func (c *Carbon) pick(p *Point, tried map[int]bool, count int) []int {
+	now := time.Now()
+	c.Lock()
+	defer c.Unlock()
+	order := c.ring.order(c.hashKey(p))
+	result := make([]int, 0, count)
+	for _, ignoreDown := range []bool{false, true} {
+		for _, n := range order {
+			if len(result) >= count {
+				break
+			}
+			if tried[n] || (!ignoreDown && now.Before(c.nodes[n].downUntil)) {
+				continue
+			}
+			tried[n] = true
+			result = append(result, n)
+		}
+		if len(result) > 0 {
+			break
+		}
+	}
+	return result
+}
+
+// hashKey is the metric written, so carbon-relay would route it the same
+func (c *Carbon) hashKey(p *Point) string {
+	if c.conf.Format == GraphiteFormatTagged {
+		return graphiteTagged(p)
+	}
+	return p.Key
+}
+
+func (c *Carbon) markDown(n int) {
+	c.Lock()
+	defer c.Unlock()
+	c.nodes[n].downUntil = time.Now().Add(time.Duration(c.conf.DownSeconds) * time.Second)
+}
+
+// writeNodes write points of each node concurrently, return errors of nodes failed
+func (c *Carbon) writeNodes(points []*Point, pending map[int][]int) map[int]*carbonWriteError {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[int]*carbonWriteError)
+	for n, indexes := range pending {
+		batch := make([]*Point, 0, len(indexes))
+		for _, i := range indexes {
+			batch = append(batch, points[i])
+		}
+		wg.Add(1)
+		go func(n int, indexes []int, batch []*Point) {
+			defer wg.Done()
+			if err := c.nodes[n].Write(batch); err != nil {
+				mu.Lock()
+				failed[n] = &carbonWriteError{err: err, indexes: indexes}
+				mu.Unlock()
+			}
+		}(n, indexes, batch)
+	}
+	wg.Wait()
+	return failed
+}
+
+type carbonWriteError struct {
+	err     error
+	indexes []int
+}
+
+func (e *carbonWriteError) Error() string {
+	return e.err.Error()
+}
@@ -0,0 +1,72 @@
+package output
+
+import (
+	"reflect"
+	"testing"
+)
+
+// the last node has no instance, it is hashed as ('10.0.0.4', None)
+var carbonTestDestinations = []string{"10.0.0.1:2003:a", "10.0.0.2:2003:b", "10.0.0.3:2004:c", "10.0.0.4:2003"}
+
+// orders are of get_nodes of ConsistentHashRing in carbon/hashing.py, with the same destinations
+func TestCarbonRingOrder(t *testing.T) {
+	tests := []struct {
+		hashType     string
+		destinations []string
+		key          string
+		want         []int
+	}{
+		{CarbonHashMD5, carbonTestDestinations, "servers.web01.cpu.user", []int{1, 2, 3, 0}},
+		{CarbonHashMD5, carbonTestDestinations, "servers.web02.cpu.user", []int{2, 0, 3, 1}},
+		{CarbonHashMD5, carbonTestDestinations, "stats.counters.hits", []int{3, 1, 2, 0}},
+		{CarbonHashMD5, carbonTestDestinations, "a", []int{2, 0, 1, 3}},
+		{CarbonHashMD5, carbonTestDestinations, "disk;host=db01", []int{3, 1, 0, 2}},
+		{CarbonHashFNV1, carbonTestDestinations[:3], "servers.web01.cpu.user", []int{2, 1, 0}},
+		{CarbonHashFNV1, carbonTestDestinations[:3], "servers.web02.cpu.user", []int{2, 1, 0}},
+		{CarbonHashFNV1, carbonTestDestinations[:3], "stats.counters.hits", []int{1, 2, 0}},
+		{CarbonHashFNV1, carbonTestDestinations[:3], "a", []int{0, 2, 1}},
+		{CarbonHashFNV1, carbonTestDestinations[:3], "disk;host=db01", []int{1, 0, 2}},
+	}
+	for _, tt := range tests {
+		c, err := NewCarbon(CarbonConfig{Destinations: tt.destinations, HashType: tt.hashType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := c.ring.order(tt.key)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s: got %v, want %v", tt.hashType, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCarbonRingKey(t *testing.T) {
+	tests := []struct {
+		node     carbonNode
+		hashType string
+		want     string
+	}{
+		{carbonNode{server: "10.0.0.1", instance: "a"}, CarbonHashMD5, "('10.0.0.1', 'a'):7"},
+		{carbonNode{server: "10.0.0.1"}, CarbonHashMD5, "('10.0.0.1', None):7"},
+		{carbonNode{server: "10.0.0.1", instance: "a"}, CarbonHashFNV1, "7-a"},
+	}
+	for _, tt := range tests {
+		if got := tt.node.ringKey(tt.hashType, 7); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.hashType, got, tt.want)
+		}
+	}
+}
+
+func TestNewCarbonError(t *testing.T) {
+	tests := []CarbonConfig{
+		{},
+		{Destinations: []string{"10.0.0.1"}},
+		{Destinations: []string{":2003:a"}},
+		{Destinations: []string{"10.0.0.1:2003:a:b"}},
+		{Destinations: []string{"10.0.0.1:2003"}, HashType: "crc32"},
+	}
+	for _, conf := range tests {
+		if _, err := NewCarbon(conf); err == nil {
+			t.Errorf("%v: want error", conf)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package output
+
+/*
+ write points to a local file or stdout, for hosts without network to metrics servers,
+ and to see exactly what the agent emits while developing collectors.
+
+ file is rotated like log files of v-util/log, when it's larger than rotate_size or
+ opened longer than rotate_seconds, to a name with date and number:
+
+	/var/lib/v-collect/points.out
+	/var/lib/v-collect/points.out.2017-07-14.001
+	/var/lib/v-collect/points.out.2017-07-14.002
+
+ only the latest max_files rotated files are kept, all if 0.
+*/
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFilePath   = "/var/lib/v-collect/points.out"
+	DefaultFileFormat = FormatGraphite
+)
+
+type FileConfig struct {
+	Enable bool   `toml:"enable"`
+	Path   string `toml:"path"`
+	Format string `toml:"format"`
+	// rotate if larger than bytes, 0 for no limit
+	RotateSize int64 `toml:"rotate_size"`
+	// rotate if opened longer than seconds, 0 for no limit
+	RotateSeconds int `toml:"rotate_seconds"`
+	MaxFiles      int `toml:"max_files"`
+	RouteConfig
+}
+
+type StdoutConfig struct {
+	Enable bool   `toml:"enable"`
+	Format string `toml:"format"`
+	RouteConfig
+}
+
+// NewFile return error if format is unknown or file can't be opened
+func NewFile(conf FileConfig) (*File, error) {
+	if conf.Path == "" {
+		conf.Path = DefaultFilePath
+	}
+	if conf.Format == "" {
+		conf.Format = DefaultFileFormat
+	}
+	if err := checkFormat(conf.Format); err != nil {
+		return nil, err
+	}
+	f := &File{
+		conf: conf,
+		rotator: &fileRotator{
+			FilePath:      conf.Path,
+			MaxSize:       conf.RotateSize,
+			RotateSeconds: conf.RotateSeconds,
+			MaxFiles:      conf.MaxFiles,
+		},
+	}
+	if err := f.rotator.Init(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// File write points to a file rotated
+type File struct {
+	conf    FileConfig
+	rotator *fileRotator
+}
+
+func (f *File) Name() string {
+	return "file:" + f.conf.Path
+}
+
+func (f *File) Write(points []*Point) error {
+	b, err := encodePoints(f.conf.Format, points)
+	if err != nil {
+		return err
+	}
+	_, err = f.rotator.Write(b)
+	return err
+}
+
+func (f *File) Start() error {
+	return nil
+}
+
+func (f *File) Stop() {
+	f.rotator.Close()
+}
+
+// NewStdout return error if format is unknown
+func NewStdout(conf StdoutConfig) (*Stdout, error) {
+	if conf.Format == "" {
+		conf.Format = DefaultFileFormat
+	}
+	if err := checkFormat(conf.Format); err != nil {
+		return nil, err
+	}
+	return &Stdout{conf: conf, w: os.Stdout}, nil
+}
+
+// Stdout write points to stdout
+type Stdout struct {
+	conf StdoutConfig
+	w    io.Writer
+}
+
+func (s *Stdout) Name() string {
+	return "stdout"
+}
+
+func (s *Stdout) Write(points []*Point) error {
+	b, err := encodePoints(s.conf.Format, points)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(b)
+	return err
+}
+
+// fileRotator write to a file and rotate it by size or time, whole batches are
+// written to one file, so a line is never split into two files.
+type fileRotator struct {
+	sync.Mutex
+	fd *os.File
+
+	FilePath string
+
+	// Rotate at size
+	MaxSize int64
+	curSize int64
+
+	// Rotate at interval
+	RotateSeconds int
+	openTime      time.Time
+
+	// rotated files kept
+	MaxFiles int
+}
+
+func (w *fileRotator) Init() error {
+	if err := os.MkdirAll(filepath.Dir(w.FilePath), 0755); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(w.FilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	w.fd = fd
+	w.curSize = info.Size()
+	w.openTime = time.Now()
+	return nil
+}
+
+func (w *fileRotator) Write(data []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.fd == nil {
+		return 0, fmt.Errorf("file %s closed", w.FilePath)
+	}
+	if w.needRotate() {
+		if err := w.DoRotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.fd.Write(data)
+	w.curSize += int64(n)
+	return n, err
+}
+
+func (w *fileRotator) needRotate() bool {
+	if w.curSize == 0 {
+		return false
+	}
+	return (w.MaxSize > 0 && w.curSize >= w.MaxSize) ||
+		(w.RotateSeconds > 0 && time.Since(w.openTime) >= time.Duration(w.RotateSeconds)*time.Second)
+}
+
+// DoRotate rename file to name like xx.out.2013-01-01.001 and open a new one
+func (w *fileRotator) DoRotate() error {
+	// number after the latest of today, numbers freed by deleteOldFiles are not
+	// reused, so names still sort by time
+	prefix := w.FilePath + "." + time.Now().Format("2006-01-02") + "."
+	num := 1
+	if today, _ := filepath.Glob(prefix + "???"); len(today) > 0 {
+		sort.Strings(today)
+		last, _ := strconv.Atoi(strings.TrimPrefix(today[len(today)-1], prefix))
+		num = last + 1
+	}
+	if num > 999 {
+		return fmt.Errorf("rotate: cannot find free number to rename %s", w.FilePath)
+	}
+	fname := prefix + fmt.Sprintf("%03d", num)
+
+	w.fd.Close()
+	w.fd = nil
+	if err := os.Rename(w.FilePath, fname); err != nil {
+		// keep writing to the file
+		if e := w.Init(); e != nil {
+			return e
+		}
+		return fmt.Errorf("rotate: %s", err)
+	}
+	if err := w.Init(); err != nil {
+		return err
+	}
+	w.deleteOldFiles()
+	return nil
+}
+
+// deleteOldFiles keep the latest MaxFiles rotated files, names sort by date and number
+func (w *fileRotator) deleteOldFiles() {
+	if w.MaxFiles <= 0 {
+		return
+	}
+	rotated, err := filepath.Glob(w.FilePath + ".????-??-??.???")
+	if err != nil {
+		return
+	}
+	sort.Strings(rotated)
+	for i := 0; i < len(rotated)-w.MaxFiles; i++ {
+		os.Remove(rotated[i])
+	}
+}
+
+func (w *fileRotator) Close() {
+	w.Lock()
+	defer w.Unlock()
+	if w.fd != nil {
+		w.fd.Sync()
+		w.fd.Close()
+		w.fd = nil
+	}
+}
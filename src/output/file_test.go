@@ -0,0 +1,154 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestRotator return a rotator of points.out in a temp dir, with files of names given
+func newTestRotator(t *testing.T, maxSize int64, maxFiles int, files ...string) *fileRotator {
+	dir := t.TempDir()
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := &fileRotator{FilePath: filepath.Join(dir, "points.out"), MaxSize: maxSize, MaxFiles: maxFiles}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Close)
+	return w
+}
+
+// readDir return content of files in dir of the rotator by name
+func readDir(t *testing.T, w *fileRotator) map[string]string {
+	dir := filepath.Dir(w.FilePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(b)
+	}
+	return files
+}
+
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileRotatorSize(t *testing.T) {
+	today := "points.out." + time.Now().Format("2006-01-02") + "."
+	w := newTestRotator(t, 10, 0)
+	// a batch is never split, the file is rotated before the batch after it is full
+	for _, batch := range []string{"a.b 1 1\na.b 2 2\n", "a.b 3 3\n", "a.b 4 4\n", "a.b 5 5\n"} {
+		if _, err := w.Write([]byte(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := readDir(t, w)
+	want := map[string]string{
+		today + "001": "a.b 1 1\na.b 2 2\n",
+		today + "002": "a.b 3 3\na.b 4 4\n",
+		"points.out":  "a.b 5 5\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got files %v, want %v", sortedNames(got), sortedNames(want))
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s: got %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+func TestFileRotatorDoRotate(t *testing.T) {
+	today := "points.out." + time.Now().Format("2006-01-02") + "."
+	tests := []struct {
+		name     string
+		maxFiles int
+		files    []string
+		want     []string
+		rotated  string
+	}{
+		{
+			name:    "first of today",
+			files:   []string{"points.out.2013-01-01.004"},
+			want:    []string{"points.out", "points.out.2013-01-01.004", today + "001"},
+			rotated: today + "001",
+		},
+		{
+			// numbers freed are not reused
+			name:    "after the latest",
+			files:   []string{today + "002", today + "005"},
+			want:    []string{"points.out", today + "002", today + "005", today + "006"},
+			rotated: today + "006",
+		},
+		{
+			name:     "old files deleted",
+			maxFiles: 2,
+			files:    []string{"points.out.2013-01-01.001", "points.out.2013-01-02.001", today + "001", "points.out.bak"},
+			want:     []string{"points.out", today + "001", today + "002", "points.out.bak"},
+			rotated:  today + "002",
+		},
+	}
+	for _, tt := range tests {
+		w := newTestRotator(t, 0, tt.maxFiles, tt.files...)
+		w.Write([]byte("a.b 1 1\n"))
+		if err := w.DoRotate(); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		got := readDir(t, w)
+		if names := sortedNames(got); !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s: got files %v, want %v", tt.name, names, tt.want)
+			continue
+		}
+		if got["points.out"] != "" || got[tt.rotated] != "a.b 1 1\n" {
+			t.Errorf("%s: content not rotated, got %q", tt.name, got)
+		}
+	}
+}
+
+// numbers of a day are used up, the file is kept to write
+func TestFileRotatorNoFreeNumber(t *testing.T) {
+	today := "points.out." + time.Now().Format("2006-01-02") + "."
+	w := newTestRotator(t, 0, 0, today+"999")
+	w.Write([]byte("a.b 1 1\n"))
+	if err := w.DoRotate(); err == nil {
+		t.Fatal("want error")
+	}
+	got := readDir(t, w)
+	if len(got) != 2 || got["points.out"] != "a.b 1 1\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// empty file is not rotated by size or time
+func TestFileRotatorEmpty(t *testing.T) {
+	w := newTestRotator(t, 1, 0)
+	w.RotateSeconds = 1
+	w.openTime = time.Now().Add(-time.Hour)
+	if w.needRotate() {
+		t.Error("empty file need rotate")
+	}
+	w.Write([]byte("a.b 1 1\n"))
+	if !w.needRotate() {
+		t.Error("file need rotate")
+	}
+}
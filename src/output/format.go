@@ -0,0 +1,85 @@
+package output
+
+/*
+ formats of points written as text by file and stdout outputs:
+
+	graphite          prod.server-01.system.disk.total.fstype=ext4,path=/.value 52000000000 1500000000
+	graphite_tagged   system.disk.total.value;fstype=ext4;host_group=prod;host_sign=server-01;path=/ 52000000000 1500000000
+	influx            system.disk.total,fstype=ext4,host_group=prod,host_sign=server-01,path=/ value=52000000000 1500000000
+	json              {"key":"prod.server-01.system.disk.total.fstype=ext4,path=/.value","name":"system.disk.total",...}
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	FormatGraphite       = "graphite"
+	FormatGraphiteTagged = "graphite_tagged"
+	FormatInflux         = "influx"
+	FormatJSON           = "json"
+)
+
+type jsonPoint struct {
+	Key       string            `json:"key"`
+	Name      string            `json:"name"`
+	Field     string            `json:"field,omitempty"`
+	Kind      string            `json:"kind,omitempty"`
+	Tags      map[string]string `json:"tags"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+func checkFormat(format string) error {
+	switch format {
+	case FormatGraphite, FormatGraphiteTagged, FormatInflux, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+// encodePoints encode points to lines in format
+func encodePoints(format string, points []*Point) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case FormatInflux:
+		for _, line := range influxLines(points) {
+			buf.WriteString(line)
+		}
+	case FormatJSON:
+		enc := json.NewEncoder(&buf)
+		for _, p := range points {
+			err := enc.Encode(&jsonPoint{
+				Key:       p.Key,
+				Name:      p.Name,
+				Field:     p.Field,
+				Kind:      p.Kind,
+				Tags:      p.Tags,
+				Value:     p.Value,
+				Timestamp: p.Timestamp,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	case FormatGraphite, FormatGraphiteTagged:
+		for _, p := range points {
+			if format == FormatGraphiteTagged {
+				buf.WriteString(graphiteTagged(p))
+			} else {
+				buf.WriteString(p.Key)
+			}
+			buf.WriteByte(' ')
+			buf.WriteString(formatValue(p.Value))
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatInt(p.Timestamp, 10))
+			buf.WriteByte('\n')
+		}
+	default:
+		return nil, checkFormat(format)
+	}
+	return buf.Bytes(), nil
+}
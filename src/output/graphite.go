@@ -0,0 +1,119 @@
+package output
+
+/*
+ graphite plaintext protocol over tcp: <key> <value> <timestamp>\n
+
+ format of key is selected by format:
+
+	plain    key as flushed, tags are segments of path
+	         prod.server-01.system.disk.total.fstype=ext4,path=/.value
+	tagged   tagged series of graphite 1.1, tags are indexed by graphite
+	         system.disk.total.value;fstype=ext4;host_group=prod;host_sign=server-01;path=/
+*/
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// time limit seconds to connect and write a batch
+	DialTimeoutSec  = 3
+	WriteTimeoutSec = 10
+)
+
+const (
+	GraphiteFormatPlain  = "plain"
+	GraphiteFormatTagged = "tagged"
+)
+
+type GraphiteConfig struct {
+	Enable bool `toml:"enable"`
+	// graphite_addr of statsd is used if empty
+	Address string `toml:"address"`
+	Format  string `toml:"format"`
+	RouteConfig
+}
+
+func NewGraphite(conf GraphiteConfig) *Graphite {
+	if conf.Format == "" {
+		conf.Format = GraphiteFormatPlain
+	}
+	return &Graphite{
+		Addr:   conf.Address,
+		Format: conf.Format,
+	}
+}
+
+// Graphite write points to carbon by plaintext protocol
+type Graphite struct {
+	Addr   string
+	Format string
+}
+
+func (g *Graphite) Name() string {
+	return "graphite:" + g.Addr
+}
+
+func (g *Graphite) Write(points []*Point) error {
+	format := FormatGraphite
+	if g.Format == GraphiteFormatTagged {
+		format = FormatGraphiteTagged
+	}
+	b, err := encodePoints(format, points)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", g.Addr, DialTimeoutSec*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(WriteTimeoutSec * time.Second))
+	_, err = conn.Write(b)
+	return err
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// graphiteTagged format key of point like name.field;tag1=v1;tag2=v2
+func graphiteTagged(p *Point) string {
+	var buf bytes.Buffer
+	buf.WriteString(graphiteTagClean(p.Name))
+	if p.Field != "" {
+		buf.WriteByte('.')
+		buf.WriteString(graphiteTagClean(p.Field))
+	}
+	keys := make([]string, 0, len(p.Tags))
+	for k, v := range p.Tags {
+		if k != "" && v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(';')
+		buf.WriteString(graphiteTagClean(k))
+		buf.WriteByte('=')
+		// values can't start with '~'
+		buf.WriteString(strings.TrimLeft(graphiteTagClean(p.Tags[k]), "~"))
+	}
+	return buf.String()
+}
+
+// graphiteTagClean replace chars not allowed in tagged series by '_'
+func graphiteTagClean(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', ';', '!', '^', '=':
+			return '_'
+		}
+		return r
+	}, s)
+}
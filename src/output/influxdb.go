@@ -0,0 +1,288 @@
+package output
+
+/*
+ write points to influxdb in line protocol, points of the same name, tags and timestamp
+ are merged to one line with fields:
+
+	system.disk.total,fstype=ext4,host_group=prod,host_sign=server-01,path=/ value=52000000000 1500000000
+	nginx.requests,host_group=prod,host_sign=server-01 count=120,rate=24 1500000000
+
+ url decide how points are sent:
+
+	http://localhost:8086            influxdb 1.x, POST /write?db=<database>
+	http://localhost:8086 + token    influxdb 2.x, POST /api/v2/write?org=<org>&bucket=<bucket>
+	udp://localhost:8089             udp listener of influxdb 1.x, no response or retry
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultInfluxDatabase = "v_collect"
+
+	// points in one http request, lines are fewer as fields are merged
+	DefaultInfluxBatchSize = 5000
+
+	// bytes in one udp packet, safe size for most networks
+	DefaultInfluxUDPPayload = 512
+
+	DefaultInfluxTimeoutSec = 5
+	DefaultInfluxMaxRetries = 3
+)
+
+type InfluxConfig struct {
+	Enable bool   `toml:"enable"`
+	Url    string `toml:"url"`
+	// influxdb 1.x
+	Database        string `toml:"database"`
+	RetentionPolicy string `toml:"retention_policy"`
+	Username        string `toml:"username"`
+	Password        string `toml:"password"`
+	// influxdb 2.x, used if token is set
+	Token        string `toml:"token"`
+	Organization string `toml:"organization"`
+	Bucket       string `toml:"bucket"`
+
+	Gzip               bool `toml:"gzip"`
+	BatchSize          int  `toml:"batch_size"`
+	UDPPayload         int  `toml:"udp_payload"`
+	TimeoutSec         int  `toml:"timeout_sec"`
+	MaxRetries         int  `toml:"max_retries"`
+	InsecureSkipVerify bool `toml:"insecure_skip_verify"`
+	RouteConfig
+}
+
+func NewInflux(conf InfluxConfig) *Influx {
+	if conf.Database == "" {
+		conf.Database = DefaultInfluxDatabase
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultInfluxBatchSize
+	}
+	if conf.UDPPayload <= 0 {
+		conf.UDPPayload = DefaultInfluxUDPPayload
+	}
+	if conf.TimeoutSec <= 0 {
+		conf.TimeoutSec = DefaultInfluxTimeoutSec
+	}
+	if conf.MaxRetries < 0 {
+		conf.MaxRetries = 0
+	} else if conf.MaxRetries == 0 {
+		conf.MaxRetries = DefaultInfluxMaxRetries
+	}
+	return &Influx{
+		conf: conf,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify},
+			},
+			Timeout: time.Duration(conf.TimeoutSec) * time.Second,
+		},
+	}
+}
+
+// Influx write points to influxdb by http or udp
+type Influx struct {
+	conf   InfluxConfig
+	client *http.Client
+}
+
+func (i *Influx) Name() string {
+	return "influxdb:" + i.conf.Url
+}
+
+func (i *Influx) Write(points []*Point) error {
+	if strings.HasPrefix(i.conf.Url, "udp://") {
+		lines := influxLines(points)
+		if len(lines) == 0 {
+			return nil
+		}
+		return i.writeUDP(strings.TrimPrefix(i.conf.Url, "udp://"), lines)
+	}
+	// split by points, so the rest is known if a request failed
+	return writeParts(points, splitPoints(points, i.conf.BatchSize), func(part []*Point) error {
+		lines := influxLines(part)
+		if len(lines) == 0 {
+			return nil
+		}
+		return i.writeHTTP(lines)
+	})
+}
+
+func (i *Influx) writeUDP(addr string, lines []string) error {
+	conn, err := net.DialTimeout("udp", addr, DialTimeoutSec*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+len(line) > i.conf.UDPPayload {
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		buf.WriteString(line)
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+func (i *Influx) writeHTTP(lines []string) error {
+	var body bytes.Buffer
+	if i.conf.Gzip {
+		gz := gzip.NewWriter(&body)
+		for _, line := range lines {
+			io.WriteString(gz, line)
+		}
+		gz.Close()
+	} else {
+		for _, line := range lines {
+			body.WriteString(line)
+		}
+	}
+
+	var err error
+	backoff := time.Second
+	for try := 0; ; try++ {
+		var retry bool
+		retry, err = i.post(body.Bytes())
+		if err != nil && !retry {
+			return permanentError{err}
+		}
+		if err == nil || try >= i.conf.MaxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post a body, return whether it's worth to retry if failed
+func (i *Influx) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", i.writeUrl(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.conf.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if i.conf.Token != "" {
+		req.Header.Set("Authorization", "Token "+i.conf.Token)
+	} else if i.conf.Username != "" {
+		req.SetBasicAuth(i.conf.Username, i.conf.Password)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("influxdb response %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	// points rejected by 4xx are dropped, they won't be accepted next time
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func (i *Influx) writeUrl() string {
+	base := strings.TrimSuffix(i.conf.Url, "/")
+	params := url.Values{}
+	params.Set("precision", "s")
+	if i.conf.Token != "" {
+		params.Set("org", i.conf.Organization)
+		params.Set("bucket", i.conf.Bucket)
+		return base + "/api/v2/write?" + params.Encode()
+	}
+	params.Set("db", i.conf.Database)
+	if i.conf.RetentionPolicy != "" {
+		params.Set("rp", i.conf.RetentionPolicy)
+	}
+	return base + "/write?" + params.Encode()
+}
+
+// influxLines merge points to lines, each ends with '\n', in order of first point of lines
+func influxLines(points []*Point) []string {
+	type line struct {
+		head   string
+		ts     int64
+		fields []string
+	}
+	lines := make([]*line, 0)
+	index := make(map[string]*line)
+	for _, p := range points {
+		if p.Name == "" {
+			continue
+		}
+		head := influxEscape(p.Name, ", ") + influxTags(p.Tags)
+		k := fmt.Sprintf("%s %d", head, p.Timestamp)
+		l, ok := index[k]
+		if !ok {
+			l = &line{head: head, ts: p.Timestamp}
+			index[k] = l
+			lines = append(lines, l)
+		}
+		field := p.Field
+		if field == "" {
+			field = "value"
+		}
+		l.fields = append(l.fields, influxEscape(field, ",= ")+"="+formatValue(p.Value))
+	}
+
+	result := make([]string, 0, len(lines))
+	for _, l := range lines {
+		result = append(result, fmt.Sprintf("%s %s %d\n", l.head, strings.Join(l.fields, ","), l.ts))
+	}
+	return result
+}
+
+// influxTags format tags sorted by key, like ,a=1,b=2
+func influxTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		// empty tag values are invalid
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteByte(',')
+		buf.WriteString(influxEscape(k, ",= "))
+		buf.WriteByte('=')
+		buf.WriteString(influxEscape(tags[k], ",= "))
+	}
+	return buf.String()
+}
+
+// influxEscape escape chars by '\'
+func influxEscape(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var buf bytes.Buffer
+	for _, c := range s {
+		if strings.ContainsRune(chars, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
@@ -0,0 +1,245 @@
+package output
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInfluxEscape(t *testing.T) {
+	tests := []struct {
+		s, chars, want string
+	}{
+		{"system.disk.total", ", ", "system.disk.total"},
+		{"disk total", ", ", `disk\ total`},
+		{"a,b", ", ", `a\,b`},
+		{"a=b", ", ", "a=b"},
+		{"a=b c,d", ",= ", `a\=b\ c\,d`},
+		{"/var/log", ",= ", "/var/log"},
+		{"", ",= ", ""},
+	}
+	for _, tt := range tests {
+		if got := influxEscape(tt.s, tt.chars); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestInfluxLines(t *testing.T) {
+	tags := map[string]string{"host_sign": "server-01", "path": "/data disk"}
+	tests := []struct {
+		name   string
+		points []*Point
+		want   []string
+	}{
+		{
+			name: "fields merged",
+			points: []*Point{
+				{Name: "nginx.requests", Field: "count", Tags: tags, Value: 120, Timestamp: 100},
+				{Name: "nginx.requests", Field: "rate", Tags: tags, Value: 24.5, Timestamp: 100},
+			},
+			want: []string{`nginx.requests,host_sign=server-01,path=/data\ disk count=120,rate=24.5 100` + "\n"},
+		},
+		{
+			name: "timestamps and tags not merged",
+			points: []*Point{
+				{Name: "cpu", Field: "value", Value: 1, Timestamp: 100},
+				{Name: "mem", Field: "value", Value: 2, Timestamp: 100},
+				{Name: "cpu", Field: "value", Value: 3, Timestamp: 101},
+				{Name: "cpu", Field: "idle", Value: 4, Timestamp: 100},
+				{Name: "cpu", Field: "value", Tags: map[string]string{"core": "0"}, Value: 5, Timestamp: 100},
+			},
+			want: []string{
+				"cpu value=1,idle=4 100\n",
+				"mem value=2 100\n",
+				"cpu value=3 101\n",
+				"cpu,core=0 value=5 100\n",
+			},
+		},
+		{
+			name: "empty field, tag value and name",
+			points: []*Point{
+				{Name: "uptime", Tags: map[string]string{"role": "", "a b": "c,d"}, Value: 3600, Timestamp: 100},
+				{Name: "", Field: "value", Value: 1, Timestamp: 100},
+			},
+			want: []string{`uptime,a\ b=c\,d value=3600 100` + "\n"},
+		},
+		{
+			name: "escaped name and field",
+			points: []*Point{
+				{Name: "disk used,total", Field: "p 99=x", Value: -0.5, Timestamp: 100},
+			},
+			want: []string{`disk\ used\,total p\ 99\=x=-0.5 100` + "\n"},
+		},
+		{name: "no points", points: nil, want: []string{}},
+	}
+	for _, tt := range tests {
+		if got := influxLines(tt.points); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// influxServer answer requests by codes in order, the last code is repeated
+type influxServer struct {
+	sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (s *influxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	b, _ := io.ReadAll(body)
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(b))
+	code := s.codes[0]
+	if len(s.codes) > 1 {
+		s.codes = s.codes[1:]
+	}
+	w.WriteHeader(code)
+}
+
+func TestInfluxWriteHTTP(t *testing.T) {
+	points := []*Point{
+		{Name: "a", Field: "value", Value: 1, Timestamp: 100},
+		{Name: "b", Field: "value", Value: 2, Timestamp: 100},
+		{Name: "c", Field: "value", Value: 3, Timestamp: 100},
+	}
+	tests := []struct {
+		name       string
+		conf       InfluxConfig
+		codes      []int
+		wantErr    bool
+		permanent  bool
+		wantUnsent int
+		wantBodies []string
+	}{
+		{
+			name:       "v1 gzip",
+			conf:       InfluxConfig{Database: "db1", Gzip: true},
+			codes:      []int{204},
+			wantBodies: []string{"a value=1 100\nb value=2 100\nc value=3 100\n"},
+		},
+		{
+			name:       "batches",
+			conf:       InfluxConfig{Token: "t", Organization: "o", Bucket: "b", BatchSize: 2},
+			codes:      []int{204},
+			wantBodies: []string{"a value=1 100\nb value=2 100\n", "c value=3 100\n"},
+		},
+		{
+			name:       "retried 503",
+			conf:       InfluxConfig{MaxRetries: 1},
+			codes:      []int{503, 204},
+			wantBodies: []string{"a value=1 100\nb value=2 100\nc value=3 100\n", "a value=1 100\nb value=2 100\nc value=3 100\n"},
+		},
+		{
+			name:       "400 not retried",
+			conf:       InfluxConfig{MaxRetries: 1},
+			codes:      []int{400},
+			wantErr:    true,
+			permanent:  true,
+			wantBodies: []string{"a value=1 100\nb value=2 100\nc value=3 100\n"},
+		},
+		{
+			// batches accepted are not sent again
+			name:       "second batch failed",
+			conf:       InfluxConfig{BatchSize: 2, MaxRetries: -1},
+			codes:      []int{204, 500},
+			wantErr:    true,
+			wantUnsent: 1,
+			wantBodies: []string{"a value=1 100\nb value=2 100\n", "c value=3 100\n"},
+		},
+		{
+			// a batch rejected is dropped, the rest are still written
+			name:       "first batch rejected",
+			conf:       InfluxConfig{BatchSize: 2},
+			codes:      []int{400, 204},
+			wantErr:    true,
+			permanent:  true,
+			wantBodies: []string{"a value=1 100\nb value=2 100\n", "c value=3 100\n"},
+		},
+	}
+	for _, tt := range tests {
+		s := &influxServer{codes: tt.codes}
+		ts := httptest.NewServer(s)
+		tt.conf.Url = ts.URL
+		err := NewInflux(tt.conf).Write(points)
+		ts.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if err != nil && isPermanent(err) != tt.permanent {
+			t.Errorf("%s: got permanent %v, want %v", tt.name, isPermanent(err), tt.permanent)
+		}
+		if tt.wantUnsent > 0 && len(unsent(points, err)) != tt.wantUnsent {
+			t.Errorf("%s: got %d unsent, want %d", tt.name, len(unsent(points, err)), tt.wantUnsent)
+		}
+		if !reflect.DeepEqual(s.bodies, tt.wantBodies) {
+			t.Errorf("%s: got bodies %q, want %q", tt.name, s.bodies, tt.wantBodies)
+		}
+	}
+}
+
+func TestInfluxWriteUrl(t *testing.T) {
+	tests := []struct {
+		conf InfluxConfig
+		want string
+	}{
+		{
+			InfluxConfig{Url: "http://localhost:8086/"},
+			"http://localhost:8086/write?db=v_collect&precision=s",
+		},
+		{
+			InfluxConfig{Url: "http://localhost:8086", Database: "db1", RetentionPolicy: "weekly"},
+			"http://localhost:8086/write?db=db1&precision=s&rp=weekly",
+		},
+		{
+			InfluxConfig{Url: "http://localhost:8086", Token: "t", Organization: "my org", Bucket: "b"},
+			"http://localhost:8086/api/v2/write?bucket=b&org=my+org&precision=s",
+		},
+	}
+	for _, tt := range tests {
+		if got := NewInflux(tt.conf).writeUrl(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestInfluxAuth(t *testing.T) {
+	s := &influxServer{codes: []int{204}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	points := []*Point{{Name: "a", Value: 1, Timestamp: 100}}
+
+	NewInflux(InfluxConfig{Url: ts.URL, Username: "u", Password: "p"}).Write(points)
+	NewInflux(InfluxConfig{Url: ts.URL, Token: "secret"}).Write(points)
+	if len(s.requests) != 2 {
+		t.Fatalf("got %d requests", len(s.requests))
+	}
+	if u, p, ok := s.requests[0].BasicAuth(); !ok || u != "u" || p != "p" {
+		t.Errorf("got basic auth %s %s", u, p)
+	}
+	if got := s.requests[1].Header.Get("Authorization"); got != "Token secret" {
+		t.Errorf("got authorization %s", got)
+	}
+	if !strings.HasPrefix(s.requests[1].URL.Path, "/api/v2/write") {
+		t.Errorf("got path %s", s.requests[1].URL.Path)
+	}
+}
@@ -0,0 +1,181 @@
+package output
+
+/*
+ write points to opentsdb, metric is name and field of point, tags are tags of point.
+ url decide how points are sent:
+
+	tcp://localhost:4242    telnet style put command
+	                        put system.disk.total.value 1500000000 52000000000 fstype=ext4 host_group=prod host_sign=server-01 path=/
+	http://localhost:4242   POST /api/put with json body
+	                        [{"metric":"system.disk.total.value","timestamp":1500000000,"value":52000000000,"tags":{...}}]
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// points in one http request
+	DefaultOpenTSDBBatchSize = 50
+
+	DefaultOpenTSDBTimeoutSec = 5
+)
+
+type OpenTSDBConfig struct {
+	Enable             bool   `toml:"enable"`
+	Url                string `toml:"url"`
+	BatchSize          int    `toml:"batch_size"`
+	TimeoutSec         int    `toml:"timeout_sec"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	RouteConfig
+}
+
+type openTSDBPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+func NewOpenTSDB(conf OpenTSDBConfig) *OpenTSDB {
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultOpenTSDBBatchSize
+	}
+	if conf.TimeoutSec <= 0 {
+		conf.TimeoutSec = DefaultOpenTSDBTimeoutSec
+	}
+	return &OpenTSDB{
+		conf: conf,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify},
+			},
+			Timeout: time.Duration(conf.TimeoutSec) * time.Second,
+		},
+	}
+}
+
+// OpenTSDB write points to opentsdb by telnet or http api
+type OpenTSDB struct {
+	conf   OpenTSDBConfig
+	client *http.Client
+}
+
+func (o *OpenTSDB) Name() string {
+	return "opentsdb:" + o.conf.Url
+}
+
+func (o *OpenTSDB) Write(points []*Point) error {
+	if strings.HasPrefix(o.conf.Url, "tcp://") {
+		ps := newOpenTSDBPoints(points)
+		if len(ps) == 0 {
+			return nil
+		}
+		return o.writeTelnet(strings.TrimPrefix(o.conf.Url, "tcp://"), ps)
+	}
+	// split by points, so the rest is known if a request failed
+	return writeParts(points, splitPoints(points, o.conf.BatchSize), func(part []*Point) error {
+		ps := newOpenTSDBPoints(part)
+		if len(ps) == 0 {
+			return nil
+		}
+		return o.writeHTTP(ps)
+	})
+}
+
+func newOpenTSDBPoints(points []*Point) []*openTSDBPoint {
+	ps := make([]*openTSDBPoint, 0, len(points))
+	for _, p := range points {
+		if tp := newOpenTSDBPoint(p); tp != nil {
+			ps = append(ps, tp)
+		}
+	}
+	return ps
+}
+
+func (o *OpenTSDB) writeTelnet(addr string, ps []*openTSDBPoint) error {
+	conn, err := net.DialTimeout("tcp", addr, DialTimeoutSec*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(WriteTimeoutSec * time.Second))
+	w := bufio.NewWriter(conn)
+	for _, p := range ps {
+		fmt.Fprintf(w, "put %s %d %s", p.Metric, p.Timestamp, formatValue(p.Value))
+		keys := make([]string, 0, len(p.Tags))
+		for k := range p.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, " %s=%s", k, p.Tags[k])
+		}
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
+
+func (o *OpenTSDB) writeHTTP(ps []*openTSDBPoint) error {
+	body, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(o.conf.Url, "/") + "/api/put"
+	resp, err := o.client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("opentsdb response %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// newOpenTSDBPoint return nil if the point has no tags, opentsdb requires at least one
+func newOpenTSDBPoint(p *Point) *openTSDBPoint {
+	metric := p.Name
+	if p.Field != "" {
+		metric += "." + p.Field
+	}
+	tp := &openTSDBPoint{
+		Metric:    openTSDBClean(metric),
+		Timestamp: p.Timestamp,
+		Value:     p.Value,
+		Tags:      make(map[string]string, len(p.Tags)),
+	}
+	for k, v := range p.Tags {
+		if k != "" && v != "" {
+			tp.Tags[openTSDBClean(k)] = openTSDBClean(v)
+		}
+	}
+	if len(tp.Tags) == 0 {
+		return nil
+	}
+	return tp
+}
+
+// openTSDBClean replace chars not allowed in metrics and tags by '_',
+// letters, numbers, '-', '_', '.' and '/' are allowed
+func openTSDBClean(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,140 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestOpenTSDBClean(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"system.disk.total", "system.disk.total"},
+		{"/var/log", "/var/log"},
+		{"server-01_a", "server-01_a"},
+		{"disk used", "disk_used"},
+		{"a,b=c:d", "a_b_c_d"},
+		{"温度", "温度"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := openTSDBClean(tt.s); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNewOpenTSDBPoint(t *testing.T) {
+	tests := []struct {
+		name  string
+		point *Point
+		want  *openTSDBPoint
+	}{
+		{
+			name:  "field appended and cleaned",
+			point: &Point{Name: "disk used", Field: "p99", Tags: map[string]string{"path": "/data disk"}, Value: 1.5, Timestamp: 100},
+			want:  &openTSDBPoint{Metric: "disk_used.p99", Timestamp: 100, Value: 1.5, Tags: map[string]string{"path": "/data_disk"}},
+		},
+		{
+			name:  "empty field",
+			point: &Point{Name: "uptime", Tags: map[string]string{"host": "a"}, Value: 3600, Timestamp: 100},
+			want:  &openTSDBPoint{Metric: "uptime", Timestamp: 100, Value: 3600, Tags: map[string]string{"host": "a"}},
+		},
+		{
+			name:  "empty tags dropped",
+			point: &Point{Name: "cpu", Field: "value", Tags: map[string]string{"host": "a", "role": "", "": "x"}, Value: 1, Timestamp: 100},
+			want:  &openTSDBPoint{Metric: "cpu.value", Timestamp: 100, Value: 1, Tags: map[string]string{"host": "a"}},
+		},
+		// opentsdb requires at least one tag
+		{
+			name:  "no tags",
+			point: &Point{Name: "cpu", Field: "value", Value: 1, Timestamp: 100},
+		},
+		{
+			name:  "only empty tags",
+			point: &Point{Name: "cpu", Field: "value", Tags: map[string]string{"role": ""}, Value: 1, Timestamp: 100},
+		},
+	}
+	for _, tt := range tests {
+		if got := newOpenTSDBPoint(tt.point); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOpenTSDBWriteTelnet(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	o := NewOpenTSDB(OpenTSDBConfig{Url: "tcp://" + ln.Addr().String()})
+	err = o.Write([]*Point{
+		{Name: "system.disk.total", Field: "value", Tags: map[string]string{"path": "/", "fstype": "ext4"}, Value: 52000000000, Timestamp: 100},
+		{Name: "no.tags", Field: "value", Value: 1, Timestamp: 100},
+		{Name: "load", Field: "1 min", Tags: map[string]string{"host": "a"}, Value: 0.25, Timestamp: 101},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "put system.disk.total.value 100 52000000000 fstype=ext4 path=/\n" +
+		"put load.1_min 101 0.25 host=a\n"
+	if got := <-received; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOpenTSDBWriteHTTP(t *testing.T) {
+	var bodies [][]*openTSDBPoint
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/put" {
+			http.NotFound(w, r)
+			return
+		}
+		var ps []*openTSDBPoint
+		if err := json.NewDecoder(r.Body).Decode(&ps); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bodies = append(bodies, ps)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	tags := map[string]string{"host": "a"}
+	o := NewOpenTSDB(OpenTSDBConfig{Url: ts.URL + "/", BatchSize: 2})
+	err := o.Write([]*Point{
+		{Name: "a", Field: "value", Tags: tags, Value: 1, Timestamp: 100},
+		{Name: "b", Field: "value", Value: 2, Timestamp: 100},
+		// a batch without points having tags is not sent
+		{Name: "c", Field: "value", Value: 3, Timestamp: 100},
+		{Name: "d", Field: "value", Value: 4, Timestamp: 100},
+		{Name: "e", Field: "value", Tags: tags, Value: 5, Timestamp: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]*openTSDBPoint{
+		{{Metric: "a.value", Timestamp: 100, Value: 1, Tags: tags}},
+		{{Metric: "e.value", Timestamp: 100, Value: 5, Tags: tags}},
+	}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("got %d requests %+v, want %+v", len(bodies), bodies, want)
+	}
+}
@@ -0,0 +1,538 @@
+package output
+
+/*
+ export points to an opentelemetry collector or backend by otlp, protocol is one of:
+
+	http/protobuf   POST protobuf to url, like http://localhost:4318/v1/metrics
+	http/json       POST otlp json to url
+	grpc            MetricsService/Export at url, like http://localhost:4317, h2c for http
+	                and http2 over tls for https
+
+ host_group, host_sign and hostname are resource attributes host_group, host_sign and
+ host.name, other tags are attributes of data points. points are mapped to:
+
+	gauge value                     gauge named as point, like system.disk.total
+	counter count                   monotonic sum, cumulative or delta by temporality
+	counter rate                    dropped, backends compute it from sum
+	timer, histogram count,         histogram with one bucket, count, min and max,
+	  min, max, mean                sum is mean * count. unit of timer is ns
+	other fields                    gauge with field as suffix, like api.latency.std-dev
+
+ counts of timers and histograms are not reset by flush, so their histograms are cumulative
+ from the start of agent. errors are retried like push, http 429, 502, 503, 504 and grpc
+ status UNAVAILABLE, RESOURCE_EXHAUSTED ... are retryable.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OTLPProtocolProtobuf = "http/protobuf"
+	OTLPProtocolJSON     = "http/json"
+	OTLPProtocolGRPC     = "grpc"
+
+	OTLPTemporalityCumulative = "cumulative"
+	OTLPTemporalityDelta      = "delta"
+
+	DefaultOTLPHttpUrl       = "http://localhost:4318/v1/metrics"
+	DefaultOTLPGrpcUrl       = "http://localhost:4317"
+	DefaultOTLPBatchSize     = 1000
+	DefaultOTLPTimeoutSec    = 10
+	DefaultOTLPMaxRetries    = 3
+	DefaultOTLPMaxBackoffSec = 60
+
+	otlpScopeName = "github.com/coder-van/v-collect"
+	otlpGrpcPath  = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+)
+
+type OTLPConfig struct {
+	Enable   bool   `toml:"enable"`
+	Url      string `toml:"url"`
+	Protocol string `toml:"protocol"`
+	// extra headers, or grpc metadata, like authorization
+	Headers map[string]string `toml:"headers"`
+	// temporality of counters
+	Temporality        string `toml:"temporality"`
+	Gzip               bool   `toml:"gzip"`
+	BatchSize          int    `toml:"batch_size"`
+	TimeoutSec         int    `toml:"timeout_sec"`
+	MaxRetries         int    `toml:"max_retries"`
+	MaxBackoffSec      int    `toml:"max_backoff_sec"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	RouteConfig
+}
+
+// otlpError is an error response, http status or grpc status
+type otlpError struct {
+	status     int
+	grpcCode   int
+	retryAfter time.Duration
+	msg        string
+}
+
+func (e *otlpError) Error() string {
+	if e.grpcCode > 0 {
+		return fmt.Sprintf("otlp grpc status %d: %s", e.grpcCode, e.msg)
+	}
+	return fmt.Sprintf("otlp response %d: %s", e.status, e.msg)
+}
+
+// Permanent is true if the batch is rejected, it won't be accepted next time
+func (e *otlpError) Permanent() bool {
+	return !e.retryable()
+}
+
+func (e *otlpError) retryable() bool {
+	if e.grpcCode > 0 {
+		switch e.grpcCode {
+		// CANCELLED, DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED, ABORTED, OUT_OF_RANGE,
+		// UNAVAILABLE, DATA_LOSS
+		case 1, 4, 8, 10, 11, 14, 15:
+			return true
+		}
+		return false
+	}
+	switch e.status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// otlpSeries keep start time and total of a counter series
+type otlpSeries struct {
+	start uint64
+	last  uint64
+	total float64
+}
+
+// NewOTLP return error if protocol, temporality or url is invalid
+func NewOTLP(conf OTLPConfig, hostname, hostGroup, hostSign string) (*OTLP, error) {
+	if conf.Protocol == "" {
+		conf.Protocol = OTLPProtocolProtobuf
+	}
+	if conf.Url == "" {
+		conf.Url = DefaultOTLPHttpUrl
+		if conf.Protocol == OTLPProtocolGRPC {
+			conf.Url = DefaultOTLPGrpcUrl
+		}
+	}
+	if conf.Temporality == "" {
+		conf.Temporality = OTLPTemporalityCumulative
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultOTLPBatchSize
+	}
+	if conf.TimeoutSec <= 0 {
+		conf.TimeoutSec = DefaultOTLPTimeoutSec
+	}
+	if conf.MaxRetries < 0 {
+		conf.MaxRetries = 0
+	} else if conf.MaxRetries == 0 {
+		conf.MaxRetries = DefaultOTLPMaxRetries
+	}
+	if conf.MaxBackoffSec <= 0 {
+		conf.MaxBackoffSec = DefaultOTLPMaxBackoffSec
+	}
+	switch conf.Protocol {
+	case OTLPProtocolProtobuf, OTLPProtocolJSON, OTLPProtocolGRPC:
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol %s", conf.Protocol)
+	}
+	if conf.Temporality != OTLPTemporalityCumulative && conf.Temporality != OTLPTemporalityDelta {
+		return nil, fmt.Errorf("unknown otlp temporality %s", conf.Temporality)
+	}
+	u, err := url.Parse(conf.Url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("otlp url %s should be http or https", conf.Url)
+	}
+
+	endpoint := conf.Url
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify},
+	}
+	if conf.Protocol == OTLPProtocolGRPC {
+		endpoint = strings.TrimSuffix(conf.Url, "/") + otlpGrpcPath
+		// grpc is http2 only, without tls it's h2c with prior knowledge
+		transport.Protocols = new(http.Protocols)
+		if u.Scheme == "https" {
+			transport.Protocols.SetHTTP2(true)
+		} else {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		}
+	}
+
+	resource := []otlpKeyValue{
+		{Key: "host.name", Value: otlpAnyValue{StringValue: hostname}},
+		{Key: "host_group", Value: otlpAnyValue{StringValue: hostGroup}},
+		{Key: "host_sign", Value: otlpAnyValue{StringValue: hostSign}},
+	}
+	return &OTLP{
+		conf:     conf,
+		endpoint: endpoint,
+		resource: resource,
+		// timestamps of points are seconds, so is start, not after the first point
+		start:  uint64(time.Now().Unix()) * uint64(time.Second),
+		series: make(map[string]otlpSeries),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(conf.TimeoutSec) * time.Second,
+		},
+	}, nil
+}
+
+// OTLP export points by otlp over http or grpc
+type OTLP struct {
+	sync.Mutex
+	conf     OTLPConfig
+	endpoint string
+	resource []otlpKeyValue
+	// start of agent, start time of cumulative series
+	start uint64
+	// series of counters sent, updated only after a request is sent, so a batch failed
+	// and replayed is not counted twice
+	series map[string]otlpSeries
+	client *http.Client
+}
+
+func (o *OTLP) Name() string {
+	return "otlp:" + o.conf.Url
+}
+
+func (o *OTLP) Write(points []*Point) error {
+	parts := make([][]*Point, 0, len(points)/o.conf.BatchSize+1)
+	for start, end := 0, 0; start < len(points); start = end {
+		end = start + o.conf.BatchSize
+		if end > len(points) {
+			end = len(points)
+		}
+		// fields of a timer are kept in one batch, they make one histogram data point
+		for end < len(points) && sameHistogram(points[end-1], points[end]) {
+			end++
+		}
+		parts = append(parts, points[start:end])
+	}
+	return writeParts(points, parts, o.writeBatch)
+}
+
+func sameHistogram(a, b *Point) bool {
+	return (a.Kind == KindTimer || a.Kind == KindHistogram) && a.Kind == b.Kind &&
+		a.Name == b.Name && influxTags(a.Tags) == influxTags(b.Tags)
+}
+
+func (o *OTLP) writeBatch(points []*Point) error {
+	req, series := o.convert(points)
+	var body []byte
+	if o.conf.Protocol == OTLPProtocolJSON {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return permanentError{err}
+		}
+		body = b
+	} else {
+		body = req.marshalProto()
+	}
+	if o.conf.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+
+	maxBackoff := time.Duration(o.conf.MaxBackoffSec) * time.Second
+	backoff := time.Second
+	for try := 0; ; try++ {
+		err := o.send(body)
+		if err == nil {
+			o.commit(series)
+			return nil
+		}
+		wait := backoff
+		if e, ok := err.(*otlpError); ok {
+			if !e.retryable() {
+				return err
+			}
+			if e.retryAfter > 0 {
+				wait = e.retryAfter
+			}
+		}
+		if try >= o.conf.MaxRetries {
+			return err
+		}
+		if wait > maxBackoff {
+			return fmt.Errorf("%s, retry after %s exceeds max backoff", err, wait)
+		}
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// convert points to a request, data points of the same metric are grouped. series of
+// counters in the request are returned, to be committed once it's sent
+func (o *OTLP) convert(points []*Point) (*otlpRequest, map[string]otlpSeries) {
+	metrics := make(map[string]*otlpMetric)
+	names := make([]string, 0)
+	// keyed by name and type, a name may be a gauge and a counter of different collectors
+	metric := func(name, typ, unit string) *otlpMetric {
+		k := name + " " + typ
+		m, ok := metrics[k]
+		if !ok {
+			m = &otlpMetric{Name: name, Unit: unit}
+			metrics[k] = m
+			names = append(names, k)
+		}
+		return m
+	}
+	gauge := func(name string, attrs []otlpKeyValue, ts uint64, v float64) {
+		m := metric(name, "gauge", "")
+		if m.Gauge == nil {
+			m.Gauge = &otlpGauge{}
+		}
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, &otlpNumberPoint{
+			Attributes: attrs, TimeUnixNano: ts, AsDouble: v,
+		})
+	}
+	histograms := make(map[string]*otlpHistogramPoint)
+	series := make(map[string]otlpSeries)
+
+	o.Lock()
+	defer o.Unlock()
+	for _, p := range points {
+		attrs, attrsKey := o.attributes(p.Tags)
+		ts := uint64(p.Timestamp) * uint64(time.Second)
+		isHistogram := p.Kind == KindTimer || p.Kind == KindHistogram
+		switch {
+		case p.Kind == KindGauge && p.Field == "value":
+			gauge(p.Name, attrs, ts, p.Value)
+		case p.Kind == KindCounter && p.Field == "rate":
+		case p.Kind == KindCounter && p.Field == "count":
+			m := metric(p.Name, "sum", "")
+			if m.Sum == nil {
+				m.Sum = &otlpSum{IsMonotonic: true, AggregationTemporality: otlpTemporalityCumulative}
+				if o.conf.Temporality == OTLPTemporalityDelta {
+					m.Sum.AggregationTemporality = otlpTemporalityDelta
+				}
+			}
+			m.Sum.DataPoints = append(m.Sum.DataPoints, o.counterPoint(series, p.Name+attrsKey, attrs, ts, p.Value))
+		case isHistogram && (p.Field == "count" || p.Field == "min" || p.Field == "max" || p.Field == "mean"):
+			unit := ""
+			if p.Kind == KindTimer {
+				unit = "ns"
+			}
+			m := metric(p.Name, "histogram", unit)
+			k := p.Name + attrsKey
+			dp, ok := histograms[k]
+			if !ok {
+				if m.Histogram == nil {
+					m.Histogram = &otlpHistogram{AggregationTemporality: otlpTemporalityCumulative}
+				}
+				dp = &otlpHistogramPoint{Attributes: attrs, StartTimeUnixNano: o.start, TimeUnixNano: ts}
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, dp)
+				histograms[k] = dp
+			}
+			v := p.Value
+			switch p.Field {
+			case "count":
+				dp.Count = uint64(v)
+			case "min":
+				dp.Min = &v
+			case "max":
+				dp.Max = &v
+			case "mean":
+				// mean is kept in sum until count is known
+				dp.Sum = &v
+			}
+		case p.Field != "":
+			gauge(p.Name+"."+p.Field, attrs, ts, p.Value)
+		default:
+			gauge(p.Name, attrs, ts, p.Value)
+		}
+	}
+	for _, dp := range histograms {
+		dp.BucketCounts = otlpUint64s{dp.Count}
+		if dp.Sum != nil {
+			// values are finite as NewPoint drop others, but the product may overflow,
+			// and json can't encode Inf
+			sum := *dp.Sum * float64(dp.Count)
+			dp.Sum = &sum
+			if math.IsInf(sum, 0) {
+				dp.Sum = nil
+			}
+		}
+		if dp.Count == 0 {
+			// min and max of an empty histogram are meaningless
+			dp.Min, dp.Max = nil, nil
+		}
+	}
+
+	sm := &otlpScopeMetrics{Scope: otlpScope{Name: otlpScopeName}}
+	for _, k := range names {
+		sm.Metrics = append(sm.Metrics, metrics[k])
+	}
+	return &otlpRequest{ResourceMetrics: []*otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: o.resource},
+		ScopeMetrics: []*otlpScopeMetrics{sm},
+	}}}, series
+}
+
+// counterPoint make a data point of counter, count of point is the delta since last flush.
+// the series updated is kept in series, not in o.series until committed
+func (o *OTLP) counterPoint(series map[string]otlpSeries, key string, attrs []otlpKeyValue, ts uint64, v float64) *otlpNumberPoint {
+	s, ok := series[key]
+	if !ok {
+		if s, ok = o.series[key]; !ok {
+			s = otlpSeries{start: o.start, last: o.start}
+		}
+	}
+	dp := &otlpNumberPoint{Attributes: attrs, TimeUnixNano: ts}
+	if o.conf.Temporality == OTLPTemporalityDelta {
+		dp.StartTimeUnixNano = s.last
+		dp.AsDouble = v
+	} else {
+		s.total += v
+		dp.StartTimeUnixNano = s.start
+		dp.AsDouble = s.total
+	}
+	s.last = ts
+	series[key] = s
+	return dp
+}
+
+// commit series of a request sent
+func (o *OTLP) commit(series map[string]otlpSeries) {
+	o.Lock()
+	defer o.Unlock()
+	for k, s := range series {
+		o.series[k] = s
+	}
+}
+
+// attributes return tags except those in resource sorted by key, and a key of them
+func (o *OTLP) attributes(tags map[string]string) ([]otlpKeyValue, string) {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if k != "host_group" && k != "host_sign" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpKeyValue, 0, len(keys))
+	var buf bytes.Buffer
+	for _, k := range keys {
+		attrs = append(attrs, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: tags[k]}})
+		buf.WriteString("," + k + "=" + tags[k])
+	}
+	return attrs, buf.String()
+}
+
+func (o *OTLP) send(body []byte) error {
+	if o.conf.Protocol == OTLPProtocolGRPC {
+		return o.sendGrpc(body)
+	}
+	req, err := http.NewRequest("POST", o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if o.conf.Protocol == OTLPProtocolJSON {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+	if o.conf.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range o.conf.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	return &otlpError{
+		status:     resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		msg:        strings.TrimSpace(string(msg)),
+	}
+}
+
+// sendGrpc call Export with a grpc message frame: compressed flag, 4 bytes length, message
+func (o *OTLP) sendGrpc(body []byte) error {
+	frame := make([]byte, 5, 5+len(body))
+	if o.conf.Gzip {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	frame = append(frame, body...)
+
+	req, err := http.NewRequest("POST", o.endpoint, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Grpc-Timeout", strconv.Itoa(o.conf.TimeoutSec)+"S")
+	if o.conf.Gzip {
+		req.Header.Set("Grpc-Encoding", "gzip")
+	}
+	for k, v := range o.conf.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// trailers are read after body
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &otlpError{status: resp.StatusCode, msg: resp.Status}
+	}
+	// status is in headers if the response has no body
+	status := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		msg = resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("otlp grpc response without status")
+	}
+	if code == 0 {
+		return nil
+	}
+	if m, err := url.PathUnescape(msg); err == nil {
+		msg = m
+	}
+	return &otlpError{status: resp.StatusCode, grpcCode: code, msg: msg}
+}
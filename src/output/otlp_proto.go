@@ -0,0 +1,273 @@
+package output
+
+/*
+ messages of opentelemetry-proto metrics v1 used by otlp output, encoded by hand to
+ protobuf binary or json, field numbers are of opentelemetry/proto/metrics/v1/metrics.proto:
+
+	ExportMetricsServiceRequest  1 resource_metrics
+	ResourceMetrics              1 resource, 2 scope_metrics
+	Resource                     1 attributes
+	ScopeMetrics                 1 scope, 2 metrics
+	InstrumentationScope         1 name, 2 version
+	Metric                       1 name, 3 unit, 5 gauge, 7 sum, 9 histogram
+	Gauge                        1 data_points
+	Sum                          1 data_points, 2 aggregation_temporality, 3 is_monotonic
+	Histogram                    1 data_points, 2 aggregation_temporality
+	NumberDataPoint              7 attributes, 2 start_time_unix_nano, 3 time_unix_nano, 4 as_double
+	HistogramDataPoint           9 attributes, 2 start_time_unix_nano, 3 time_unix_nano, 4 count,
+	                             5 sum, 6 bucket_counts, 11 min, 12 max
+	KeyValue                     1 key, 2 value
+	AnyValue                     1 string_value
+
+ json follows the otlp json mapping, names are lowerCamelCase and 64 bits integers are strings.
+*/
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+const (
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+)
+
+type otlpRequest struct {
+	ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource        `json:"resource"`
+	ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope     `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpMetric has one of gauge, sum and histogram
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit,omitempty"`
+	Gauge     *otlpGauge     `json:"gauge,omitempty"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []*otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []*otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int                `json:"aggregationTemporality"`
+	IsMonotonic            bool               `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []*otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	Count             uint64         `json:"count,string"`
+	Sum               *float64       `json:"sum,omitempty"`
+	BucketCounts      otlpUint64s    `json:"bucketCounts"`
+	Min               *float64       `json:"min,omitempty"`
+	Max               *float64       `json:"max,omitempty"`
+}
+
+// otlpUint64s is repeated fixed64, strings in json
+type otlpUint64s []uint64
+
+func (u otlpUint64s) MarshalJSON() ([]byte, error) {
+	s := make([]string, len(u))
+	for i, v := range u {
+		s[i] = strconv.FormatUint(v, 10)
+	}
+	return json.Marshal(s)
+}
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// protoBuf append fields of a message in protobuf binary
+type protoBuf []byte
+
+func (b *protoBuf) tag(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protoBuf) varint(v uint64) {
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuf) fixed64(field int, v uint64) {
+	b.tag(field, wireFixed64)
+	*b = binary.LittleEndian.AppendUint64(*b, v)
+}
+
+func (b *protoBuf) double(field int, v float64) {
+	b.fixed64(field, math.Float64bits(v))
+}
+
+func (b *protoBuf) uint(field int, v uint64) {
+	b.tag(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *protoBuf) bytes(field int, v []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuf) string(field int, v string) {
+	if v != "" {
+		b.bytes(field, []byte(v))
+	}
+}
+
+// message append a message field encoded by enc
+func (b *protoBuf) message(field int, enc func(*protoBuf)) {
+	var m protoBuf
+	enc(&m)
+	b.bytes(field, m)
+}
+
+func (r *otlpRequest) marshalProto() []byte {
+	var b protoBuf
+	for _, rm := range r.ResourceMetrics {
+		b.message(1, rm.encode)
+	}
+	return b
+}
+
+func (rm *otlpResourceMetrics) encode(b *protoBuf) {
+	b.message(1, func(b *protoBuf) {
+		encodeAttributes(b, 1, rm.Resource.Attributes)
+	})
+	for _, sm := range rm.ScopeMetrics {
+		b.message(2, sm.encode)
+	}
+}
+
+func (sm *otlpScopeMetrics) encode(b *protoBuf) {
+	b.message(1, func(b *protoBuf) {
+		b.string(1, sm.Scope.Name)
+		b.string(2, sm.Scope.Version)
+	})
+	for _, m := range sm.Metrics {
+		b.message(2, m.encode)
+	}
+}
+
+func (m *otlpMetric) encode(b *protoBuf) {
+	b.string(1, m.Name)
+	b.string(3, m.Unit)
+	switch {
+	case m.Gauge != nil:
+		b.message(5, func(b *protoBuf) {
+			for _, dp := range m.Gauge.DataPoints {
+				b.message(1, dp.encode)
+			}
+		})
+	case m.Sum != nil:
+		b.message(7, func(b *protoBuf) {
+			for _, dp := range m.Sum.DataPoints {
+				b.message(1, dp.encode)
+			}
+			b.uint(2, uint64(m.Sum.AggregationTemporality))
+			if m.Sum.IsMonotonic {
+				b.uint(3, 1)
+			}
+		})
+	case m.Histogram != nil:
+		b.message(9, func(b *protoBuf) {
+			for _, dp := range m.Histogram.DataPoints {
+				b.message(1, dp.encode)
+			}
+			b.uint(2, uint64(m.Histogram.AggregationTemporality))
+		})
+	}
+}
+
+func (dp *otlpNumberPoint) encode(b *protoBuf) {
+	encodeAttributes(b, 7, dp.Attributes)
+	if dp.StartTimeUnixNano > 0 {
+		b.fixed64(2, dp.StartTimeUnixNano)
+	}
+	b.fixed64(3, dp.TimeUnixNano)
+	b.double(4, dp.AsDouble)
+}
+
+func (dp *otlpHistogramPoint) encode(b *protoBuf) {
+	encodeAttributes(b, 9, dp.Attributes)
+	if dp.StartTimeUnixNano > 0 {
+		b.fixed64(2, dp.StartTimeUnixNano)
+	}
+	b.fixed64(3, dp.TimeUnixNano)
+	b.fixed64(4, dp.Count)
+	if dp.Sum != nil {
+		b.double(5, *dp.Sum)
+	}
+	// packed repeated fixed64
+	packed := make([]byte, 0, 8*len(dp.BucketCounts))
+	for _, c := range dp.BucketCounts {
+		packed = binary.LittleEndian.AppendUint64(packed, c)
+	}
+	b.bytes(6, packed)
+	if dp.Min != nil {
+		b.double(11, *dp.Min)
+	}
+	if dp.Max != nil {
+		b.double(12, *dp.Max)
+	}
+}
+
+func encodeAttributes(b *protoBuf, field int, attrs []otlpKeyValue) {
+	for _, kv := range attrs {
+		b.message(field, func(b *protoBuf) {
+			b.string(1, kv.Key)
+			b.message(2, func(b *protoBuf) {
+				// empty string_value is still set, so the value is not empty AnyValue
+				b.bytes(1, []byte(kv.Value.StringValue))
+			})
+		})
+	}
+}
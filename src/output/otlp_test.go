@@ -0,0 +1,349 @@
+package output
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otlpServer answer requests by codes in order, the last code is repeated, json
+// requests are decoded
+type otlpServer struct {
+	sync.Mutex
+	codes    []int
+	requests []*otlpRequest
+}
+
+func (s *otlpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	req := &otlpRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.requests = append(s.requests, req)
+	code := s.codes[0]
+	if len(s.codes) > 1 {
+		s.codes = s.codes[1:]
+	}
+	w.WriteHeader(code)
+}
+
+// a batch failed and replayed is not counted twice in series of counters
+func TestOTLPCounterReplay(t *testing.T) {
+	sec := uint64(time.Second)
+	tests := []struct {
+		temporality string
+		// start and value of data points sent, the first one is failed
+		wantStarts []uint64
+		wantValues []float64
+	}{
+		{OTLPTemporalityCumulative, []uint64{0, 0, 0}, []float64{5, 5, 8}},
+		{OTLPTemporalityDelta, []uint64{0, 0, 100 * sec}, []float64{5, 5, 3}},
+	}
+	for _, tt := range tests {
+		s := &otlpServer{codes: []int{503, 200}}
+		ts := httptest.NewServer(s)
+		o, err := NewOTLP(OTLPConfig{Url: ts.URL, Protocol: OTLPProtocolJSON, Temporality: tt.temporality, MaxRetries: -1},
+			"server-01", "prod", "server-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter := func(v float64, timestamp int64) []*Point {
+			return []*Point{{Name: "nginx.requests", Field: "count", Kind: KindCounter, Value: v, Timestamp: timestamp}}
+		}
+		if err := o.Write(counter(5, 100)); err == nil || isPermanent(err) {
+			t.Errorf("%s: got error %v, want retryable", tt.temporality, err)
+		}
+		if err := o.Write(counter(5, 100)); err != nil {
+			t.Errorf("%s: replay got error %v", tt.temporality, err)
+		}
+		if err := o.Write(counter(3, 110)); err != nil {
+			t.Errorf("%s: got error %v", tt.temporality, err)
+		}
+		ts.Close()
+
+		if len(s.requests) != 3 {
+			t.Fatalf("%s: got %d requests, want 3", tt.temporality, len(s.requests))
+		}
+		for i, req := range s.requests {
+			dp := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Sum.DataPoints[0]
+			start := tt.wantStarts[i]
+			if start == 0 {
+				start = o.start
+			}
+			if dp.StartTimeUnixNano != start || dp.AsDouble != tt.wantValues[i] {
+				t.Errorf("%s: request %d got start %d value %v, want %d %v",
+					tt.temporality, i, dp.StartTimeUnixNano, dp.AsDouble, start, tt.wantValues[i])
+			}
+		}
+	}
+}
+
+func TestOTLPWriteRetry(t *testing.T) {
+	points := []*Point{{Name: "system.load", Field: "value", Kind: KindGauge, Value: 1, Timestamp: 100}}
+	tests := []struct {
+		name      string
+		codes     []int
+		wantErr   bool
+		permanent bool
+		requests  int
+	}{
+		{"accepted", []int{200}, false, false, 1},
+		{"503 retried", []int{503, 200}, false, false, 2},
+		{"429 retried", []int{429, 200}, false, false, 2},
+		{"500 not retried", []int{500}, true, true, 1},
+		{"400 not retried", []int{400}, true, true, 1},
+		{"retries exhausted", []int{504}, true, false, 2},
+	}
+	for _, tt := range tests {
+		s := &otlpServer{codes: tt.codes}
+		ts := httptest.NewServer(s)
+		o, err := NewOTLP(OTLPConfig{Url: ts.URL, Protocol: OTLPProtocolJSON, MaxRetries: 1}, "server-01", "prod", "server-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = o.Write(points)
+		ts.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if err != nil && isPermanent(err) != tt.permanent {
+			t.Errorf("%s: got permanent %v, want %v", tt.name, isPermanent(err), tt.permanent)
+		}
+		if len(s.requests) != tt.requests {
+			t.Errorf("%s: got %d requests, want %d", tt.name, len(s.requests), tt.requests)
+		}
+	}
+}
+
+func TestOTLPMarshalProto(t *testing.T) {
+	sum, min, max := 10.0, 1.0, 4.0
+	tests := []struct {
+		name string
+		req  *otlpRequest
+		// hex of fields, a message is its tag and length followed by its fields
+		want string
+	}{
+		{
+			name: "gauge",
+			req: &otlpRequest{ResourceMetrics: []*otlpResourceMetrics{{
+				Resource: otlpResource{Attributes: []otlpKeyValue{{Key: "host.name", Value: otlpAnyValue{StringValue: "a"}}}},
+				ScopeMetrics: []*otlpScopeMetrics{{
+					Scope: otlpScope{Name: "s"},
+					Metrics: []*otlpMetric{{
+						Name:  "g",
+						Gauge: &otlpGauge{DataPoints: []*otlpNumberPoint{{TimeUnixNano: 1, AsDouble: 1.5}}},
+					}},
+				}},
+			}}},
+			want: "0a36" + // resource_metrics
+				"0a12" + "0a10" + "0a09686f73742e6e616d65" + "1203" + "0a0161" + // resource, attributes host.name=a
+				"1220" + // scope_metrics
+				"0a03" + "0a0173" + // scope, name s
+				"1219" + "0a0167" + // metrics, name g
+				"2a14" + "0a12" + // gauge, data_points
+				"190100000000000000" + // time_unix_nano
+				"21000000000000f83f", // as_double 1.5
+		},
+		{
+			name: "sum",
+			req: &otlpRequest{ResourceMetrics: []*otlpResourceMetrics{{
+				ScopeMetrics: []*otlpScopeMetrics{{
+					Metrics: []*otlpMetric{{
+						Name: "c",
+						Sum: &otlpSum{
+							DataPoints: []*otlpNumberPoint{{
+								Attributes:        []otlpKeyValue{{Key: "k"}},
+								StartTimeUnixNano: 1,
+								TimeUnixNano:      2,
+								AsDouble:          3,
+							}},
+							AggregationTemporality: otlpTemporalityCumulative,
+							IsMonotonic:            true,
+						},
+					}},
+				}},
+			}}},
+			want: "0a37" + "0a00" + // resource_metrics, resource
+				"1233" + "0a00" + // scope_metrics, scope
+				"122f" + "0a0163" + // metrics, name c
+				"3a2a" + "0a24" + // sum, data_points
+				"3a07" + "0a016b" + "1202" + "0a00" + // attributes k, empty string_value is set
+				"110100000000000000" + // start_time_unix_nano
+				"190200000000000000" + // time_unix_nano
+				"210000000000000840" + // as_double 3
+				"1002" + // aggregation_temporality cumulative
+				"1801", // is_monotonic
+		},
+		{
+			name: "histogram",
+			req: &otlpRequest{ResourceMetrics: []*otlpResourceMetrics{{
+				ScopeMetrics: []*otlpScopeMetrics{{
+					Metrics: []*otlpMetric{{
+						Name: "t",
+						Unit: "ns",
+						Histogram: &otlpHistogram{
+							DataPoints: []*otlpHistogramPoint{{
+								StartTimeUnixNano: 1,
+								TimeUnixNano:      2,
+								Count:             4,
+								Sum:               &sum,
+								BucketCounts:      otlpUint64s{4},
+								Min:               &min,
+								Max:               &max,
+							}},
+							AggregationTemporality: otlpTemporalityCumulative,
+						},
+					}},
+				}},
+			}}},
+			want: "0a55" + "0a00" + // resource_metrics, resource
+				"1251" + "0a00" + // scope_metrics, scope
+				"124d" + "0a0174" + "1a026e73" + // metrics, name t, unit ns
+				"4a44" + "0a40" + // histogram, data_points
+				"110100000000000000" + // start_time_unix_nano
+				"190200000000000000" + // time_unix_nano
+				"210400000000000000" + // count
+				"290000000000002440" + // sum 10
+				"32080400000000000000" + // bucket_counts, packed
+				"59000000000000f03f" + // min 1
+				"610000000000001040" + // max 4
+				"1002", // aggregation_temporality cumulative
+		},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.req.marshalProto()); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// grpcResponse is a response of grpcServer, status is in headers if trailersOnly
+type grpcResponse struct {
+	httpStatus   int
+	status       string
+	message      string
+	trailersOnly bool
+}
+
+func newGrpcServer(resp grpcResponse, frames chan []byte, headers chan http.Header) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		frames <- b
+		headers <- r.Header
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if resp.httpStatus != http.StatusOK {
+			w.WriteHeader(resp.httpStatus)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		if resp.trailersOnly {
+			w.Header().Set("Grpc-Status", resp.status)
+			w.Header().Set("Grpc-Message", resp.message)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// an empty ExportMetricsServiceResponse
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", resp.status)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", resp.message)
+	}))
+	// h2c with prior knowledge, like grpc without tls
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	return ts
+}
+
+func TestOTLPSendGrpc(t *testing.T) {
+	body := []byte("metrics")
+	tests := []struct {
+		name      string
+		gzip      bool
+		resp      grpcResponse
+		wantErr   bool
+		permanent bool
+		grpcCode  int
+		msg       string
+	}{
+		{name: "ok", resp: grpcResponse{httpStatus: 200, status: "0"}},
+		{name: "gzip", gzip: true, resp: grpcResponse{httpStatus: 200, status: "0"}},
+		{name: "trailers only", resp: grpcResponse{httpStatus: 200, status: "0", trailersOnly: true}},
+		{
+			name:     "unavailable",
+			resp:     grpcResponse{httpStatus: 200, status: "14", message: "try%20later"},
+			wantErr:  true,
+			grpcCode: 14,
+			msg:      "try later",
+		},
+		{
+			name:      "invalid argument",
+			resp:      grpcResponse{httpStatus: 200, status: "3", message: "bad", trailersOnly: true},
+			wantErr:   true,
+			permanent: true,
+			grpcCode:  3,
+			msg:       "bad",
+		},
+		{name: "http 503", resp: grpcResponse{httpStatus: 503}, wantErr: true},
+		{name: "no status", resp: grpcResponse{httpStatus: 200}, wantErr: true},
+	}
+	for _, tt := range tests {
+		frames := make(chan []byte, 1)
+		headers := make(chan http.Header, 1)
+		ts := newGrpcServer(tt.resp, frames, headers)
+		o, err := NewOTLP(OTLPConfig{
+			Url:      ts.URL,
+			Protocol: OTLPProtocolGRPC,
+			Gzip:     tt.gzip,
+			Headers:  map[string]string{"Authorization": "Bearer t"},
+		}, "server-01", "prod", "server-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = o.send(body)
+		ts.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if err != nil && isPermanent(err) != tt.permanent {
+			t.Errorf("%s: got permanent %v, want %v", tt.name, isPermanent(err), tt.permanent)
+		}
+		if tt.grpcCode > 0 {
+			e, ok := err.(*otlpError)
+			if !ok || e.grpcCode != tt.grpcCode || e.msg != tt.msg {
+				t.Errorf("%s: got error %#v, want grpc status %d %q", tt.name, err, tt.grpcCode, tt.msg)
+			}
+		}
+
+		// compressed flag, 4 bytes length and message
+		flag := byte(0)
+		if tt.gzip {
+			flag = 1
+		}
+		want := append([]byte{flag, 0, 0, 0, byte(len(body))}, body...)
+		if got := <-frames; !bytes.Equal(got, want) {
+			t.Errorf("%s: got frame %x, want %x", tt.name, got, want)
+		}
+		h := <-headers
+		if h.Get("Content-Type") != "application/grpc" || h.Get("Te") != "trailers" ||
+			h.Get("Grpc-Timeout") != "10S" || h.Get("Authorization") != "Bearer t" {
+			t.Errorf("%s: got headers %v", tt.name, h)
+		}
+		if tt.gzip && h.Get("Grpc-Encoding") != "gzip" {
+			t.Errorf("%s: got grpc-encoding %q", tt.name, h.Get("Grpc-Encoding"))
+		}
+	}
+}
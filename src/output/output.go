@@ -0,0 +1,362 @@
+package output
+
+/*
+ output manager, read data points flushed by statsd aggregator and route them to outputs.
+
+ each output has its own queue, buffer and flush loop, so a slow output don't hold back
+ the others, points are dropped if its queue is full. points are routed by name, key
+ without host prefix, tags and field, like system.disk.total:
+
+	[[output.influxdb]]
+	include = ["system.*"]
+
+	[[output.prometheus]]
+	include = ["nginx.*"]
+
+ self metrics of outputs, key like: agent.output.graphite_2003.dropped
+
+	queue     points waiting in queue
+	written   points written, or spooled if spool enabled, counter
+	dropped   points dropped when queue is full, counter
+	errors    batches failed to write, counter
+	rerouted  points rerouted to other nodes by carbon output, counter
+*/
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+	"github.com/coder-van/v-util/log"
+)
+
+const (
+	// queue size of an output is flush_size multiplied by it if not set
+	DefaultQueueBatches = 10
+)
+
+// Output write batches of points to somewhere
+type Output interface {
+	Name() string
+	Write(points []*Point) error
+}
+
+// PartialError is returned by outputs writing a batch in parts, points not in Unsent
+// are written, so only Unsent are worth to write again
+type PartialError struct {
+	Err    error
+	Unsent []*Point
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%s, %d points unsent", e.Err, len(e.Unsent))
+}
+
+// unsent return points of the batch not written if write failed by err
+func unsent(points []*Point, err error) []*Point {
+	if e, ok := err.(*PartialError); ok {
+		return e.Unsent
+	}
+	return points
+}
+
+// PermanentError is implemented by errors of batches which will never be accepted,
+// like rejected by 4xx, they are dropped instead of spooled
+type PermanentError interface {
+	Permanent() bool
+}
+
+func isPermanent(err error) bool {
+	e, ok := err.(PermanentError)
+	return ok && e.Permanent()
+}
+
+// permanentError mark an error as permanent
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Permanent() bool {
+	return true
+}
+
+// splitPoints split points to parts of size at most
+func splitPoints(points []*Point, size int) [][]*Point {
+	parts := make([][]*Point, 0, (len(points)+size-1)/size)
+	for start := 0; start < len(points); start += size {
+		end := start + size
+		if end > len(points) {
+			end = len(points)
+		}
+		parts = append(parts, points[start:end])
+	}
+	return parts
+}
+
+// writeParts write parts of points in order by write. A part failed permanently is
+// dropped and the rest are still written, if a part failed otherwise it's returned
+// with the rest as unsent, so parts written before are not written again.
+func writeParts(points []*Point, parts [][]*Point, write func([]*Point) error) error {
+	var dropped error
+	sent := 0
+	for _, part := range parts {
+		if err := write(part); err != nil {
+			if !isPermanent(err) {
+				if sent > 0 {
+					return &PartialError{Err: err, Unsent: points[sent:]}
+				}
+				return err
+			}
+			dropped = err
+		}
+		sent += len(part)
+	}
+	return dropped
+}
+
+// statOutput is an output with self metrics, registry is set when registered
+type statOutput interface {
+	setRegistry(registry metrics.Registry)
+}
+
+// Service is an output running by itself, like a http listener
+type Service interface {
+	Start() error
+	Stop()
+}
+
+// RouteConfig is embedded in config of outputs, options are set in the output section itself
+type RouteConfig struct {
+	// glob patterns of names, all points if empty, exclude is applied after include
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+	// defaults of manager are used if not set
+	FlushSeconds int `toml:"flush_seconds"`
+	FlushSize    int `toml:"flush_size"`
+	QueueSize    int `toml:"queue_size"`
+}
+
+// match test name by include and exclude patterns
+func (r RouteConfig) match(name string) bool {
+	if len(r.Include) > 0 && !routeMatch(r.Include, name) {
+		return false
+	}
+	return !routeMatch(r.Exclude, name)
+}
+
+func routeMatch(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type ManagerConfig struct {
+	FlushSeconds int
+	BatchSize    int
+	HostGroup    string
+	HostSign     string
+	Tags         map[string]string
+	Spool        SpoolConfig
+}
+
+func NewManager(conf ManagerConfig, registry metrics.Registry, in chan metrics.MetricDataPoint) *Manager {
+	conf.Spool.setDefault()
+	prefix := conf.HostGroup + "." + conf.HostSign + "."
+	return &Manager{
+		exit:         make(chan bool),
+		conf:         conf,
+		prefix:       prefix,
+		hostTags:     map[string]string{"host_group": conf.HostGroup, "host_sign": conf.HostSign},
+		registry:     registry,
+		selfRegistry: util.NewTaggedRegistry(metrics.NewPrefixedChildRegistry(registry, prefix), conf.Tags),
+		dataPointCh:  in,
+		logger:       log.GetLogger("output", log.RotateModeMonth),
+	}
+}
+
+// Manager parse points and route them to outputs
+type Manager struct {
+	exit     chan bool
+	conf     ManagerConfig
+	prefix   string
+	hostTags map[string]string
+	registry metrics.Registry
+	// registry for self metrics, with host prefix
+	selfRegistry metrics.Registry
+	dataPointCh  chan metrics.MetricDataPoint
+	workers      []*outputWorker
+	logger       *log.Vlogger
+}
+
+// RegisterOutput wrap the output by spool if spool enabled
+func (m *Manager) RegisterOutput(o Output, route RouteConfig) {
+	m.logger.Printf("RegisterOutput %s", o.Name())
+	if so, ok := o.(statOutput); ok {
+		so.setRegistry(m.selfRegistry)
+	}
+	if m.conf.Spool.Enable {
+		so, err := newSpooledOutput(o, m.conf.Spool, m.selfRegistry)
+		if err != nil {
+			m.logger.Printf("Error occurred when open spool of %s: %s", o.Name(), err)
+		} else {
+			o = so
+		}
+	}
+	if route.FlushSeconds <= 0 {
+		route.FlushSeconds = m.conf.FlushSeconds
+	}
+	if route.FlushSize <= 0 {
+		route.FlushSize = m.conf.BatchSize
+	}
+	if route.QueueSize <= 0 {
+		route.QueueSize = route.FlushSize * DefaultQueueBatches
+	}
+	m.workers = append(m.workers, newOutputWorker(o, route, m.selfRegistry, m.logger))
+}
+
+// Len return count of outputs registered
+func (m *Manager) Len() int {
+	return len(m.workers)
+}
+
+func (m *Manager) run(shutdown chan bool) {
+	defer close(m.exit)
+
+	m.logger.Println("OutputManager started")
+	for {
+		select {
+		case <-shutdown:
+			m.logger.Println("OutputManager stoped")
+			return
+		case dp := <-m.dataPointCh:
+			p := NewPoint(dp, m.prefix, m.hostTags, m.registry)
+			if p == nil {
+				continue
+			}
+			for _, w := range m.workers {
+				w.route(p)
+			}
+		}
+	}
+}
+
+func (m *Manager) Start() {
+	m.logger.Println("OutputManager starting")
+	for _, w := range m.workers {
+		w.start()
+	}
+	go m.run(m.exit)
+}
+
+// Stop stop routing, then workers flush points queued and stop
+func (m *Manager) Stop() {
+	m.logger.Println("OutputManager stoping")
+	m.exit <- true
+	<-m.exit
+	for _, w := range m.workers {
+		w.stop()
+	}
+}
+
+func newOutputWorker(o Output, route RouteConfig, registry metrics.Registry, logger *log.Vlogger) *outputWorker {
+	return &outputWorker{
+		Output:   o,
+		BaseStat: metrics.NewBaseStat("agent.output", registry),
+		conf:     route,
+		key:      util.SanitizeKey(o.Name()),
+		queue:    make(chan *Point, route.QueueSize),
+		buffer:   make([]*Point, 0, route.FlushSize),
+		exit:     make(chan bool),
+		logger:   logger,
+	}
+}
+
+// outputWorker buffer points routed to an output and flush them
+type outputWorker struct {
+	Output
+	*metrics.BaseStat
+	conf   RouteConfig
+	key    string
+	queue  chan *Point
+	buffer []*Point
+	exit   chan bool
+	logger *log.Vlogger
+}
+
+// route queue the point if matched, never block
+func (w *outputWorker) route(p *Point) {
+	if !w.conf.match(p.Name) {
+		return
+	}
+	select {
+	case w.queue <- p:
+	default:
+		w.CounterInc(w.key+".dropped", 1)
+	}
+}
+
+func (w *outputWorker) run(shutdown chan bool) {
+	defer close(w.exit)
+
+	ticker := time.NewTicker(time.Duration(w.conf.FlushSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			// points queued before stop
+			for len(w.queue) > 0 {
+				w.buffer = append(w.buffer, <-w.queue)
+			}
+			w.flush()
+			return
+		case <-ticker.C:
+			w.flush()
+		case p := <-w.queue:
+			w.buffer = append(w.buffer, p)
+			if len(w.buffer) >= w.conf.FlushSize {
+				w.flush()
+			}
+		}
+	}
+}
+
+func (w *outputWorker) flush() {
+	w.GaugeUpdate(w.key+".queue", len(w.queue))
+	if len(w.buffer) == 0 {
+		return
+	}
+	batch := w.buffer
+	w.buffer = make([]*Point, 0, w.conf.FlushSize)
+	if err := w.Write(batch); err != nil {
+		if n := len(batch) - len(unsent(batch, err)); n > 0 {
+			w.CounterInc(w.key+".written", n)
+		}
+		w.CounterInc(w.key+".errors", 1)
+		w.logger.Printf("Error occurred when write to %s: %s", w.Name(), err)
+		return
+	}
+	w.CounterInc(w.key+".written", len(batch))
+}
+
+func (w *outputWorker) start() {
+	if s, ok := w.Output.(Service); ok {
+		if err := s.Start(); err != nil {
+			w.logger.Printf("Error occurred when start %s: %s", w.Name(), err)
+		}
+	}
+	go w.run(w.exit)
+}
+
+func (w *outputWorker) stop() {
+	w.exit <- true
+	<-w.exit
+	if s, ok := w.Output.(Service); ok {
+		s.Stop()
+	}
+}
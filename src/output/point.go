@@ -0,0 +1,113 @@
+package output
+
+/*
+ data points flushed by statsd aggregator are graphite keys like:
+
+	prod.server-01.system.disk.total.fstype=ext4,path=/.value
+
+ they are parsed to host prefix, name, tags and field, so outputs understanding tags
+ don't need to deal with the key:
+
+	name:  system.disk.total
+	tags:  host_group=prod host_sign=server-01 fstype=ext4 path=/
+	field: value
+*/
+
+import (
+	"math"
+	"strings"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// kind of metric in registry a point is flushed from
+const (
+	KindGauge     = "gauge"
+	KindCounter   = "counter"
+	KindTimer     = "timer"
+	KindHistogram = "histogram"
+	KindUnknown   = ""
+)
+
+// Point is a data point with tags parsed from its key
+type Point struct {
+	// graphite key as flushed
+	Key string
+	// key without host prefix, tags and field, like system.disk.total
+	Name string
+	// last segment of key, value for gauges, count and rate for counters,
+	// count, min, max, mean ... for timers
+	Field     string
+	Tags      map[string]string
+	Kind      string
+	Value     float64
+	Timestamp int64
+}
+
+// NewPoint parse a data point, prefix is removed from key and hostTags are
+// added to tags, kind is found by the key in registry. nil is returned for NaN
+// and Inf, json and line protocol of most outputs can't encode them.
+func NewPoint(dp metrics.MetricDataPoint, prefix string, hostTags map[string]string, registry metrics.Registry) *Point {
+	// keys of timers start with '.'
+	key := strings.TrimPrefix(dp.Key, ".")
+	p := &Point{
+		Key:       key,
+		Tags:      make(map[string]string, len(hostTags)+2),
+		Timestamp: dp.Timestamp,
+	}
+	switch v := dp.Value.(type) {
+	case int64:
+		p.Value = float64(v)
+	case float64:
+		p.Value = v
+	}
+	if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+		return nil
+	}
+	for k, v := range hostTags {
+		p.Tags[k] = v
+	}
+
+	rest := strings.TrimPrefix(key, prefix)
+	i := strings.LastIndex(rest, ".")
+	if i < 0 {
+		p.Name = rest
+		return p
+	}
+	p.Field = rest[i+1:]
+	rest = rest[:i]
+
+	if registry != nil {
+		p.Kind = kindOf(registry.Get(strings.TrimSuffix(key, "."+p.Field)))
+	}
+
+	// tags segment is made by metrics.MakeMetric, values may contain '.'
+	segs := strings.Split(rest, ".")
+	for j, seg := range segs {
+		if strings.Contains(seg, "=") {
+			p.Name = strings.Join(segs[:j], ".")
+			for _, kv := range strings.Split(strings.Join(segs[j:], "."), ",") {
+				if t := strings.SplitN(kv, "=", 2); len(t) == 2 && t[0] != "" {
+					p.Tags[t[0]] = t[1]
+				}
+			}
+			return p
+		}
+	}
+	p.Name = rest
+	return p
+}
+
+func kindOf(m interface{}) string {
+	switch m.(type) {
+	case metrics.Counter:
+		return KindCounter
+	case metrics.Gauge, metrics.GaugeFloat64:
+		return KindGauge
+	case metrics.Timer:
+		return KindTimer
+	case metrics.Histogram:
+		return KindHistogram
+	}
+	return KindUnknown
+}
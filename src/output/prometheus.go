@@ -0,0 +1,266 @@
+package output
+
+/*
+ expose points in prometheus text format over http, for prometheus servers to pull:
+
+	$ curl http://localhost:9273/metrics
+	# TYPE system_disk_total gauge
+	system_disk_total{fstype="ext4",host_group="prod",host_sign="server-01",path="/"} 5.2e+10
+	# TYPE nginx_requests_total counter
+	nginx_requests_total{host_group="prod",host_sign="server-01"} 10342
+
+ name of point is converted to metric name by replacing '.' with '_', tags become labels.
+ rules convert names matched to other names and labels, '*' in match is one segment of
+ name and is referred by $1, $2 ... in name and labels:
+
+	[[output.prometheus.rule]]
+	match = "mysql.*.queries"
+	name = "mysql_queries"
+	labels = { server = "$1" }
+
+ count of counters is accumulated as prometheus counter with _total suffix, rate is dropped.
+ fields of timers and histograms are gauges with field as suffix, like timer_mean.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPrometheusListen = ":9273"
+	DefaultPrometheusPath   = "/metrics"
+
+	// series not updated in this long are removed
+	DefaultPrometheusExpireSeconds = 300
+)
+
+type PrometheusConfig struct {
+	Enable        bool             `toml:"enable"`
+	Listen        string           `toml:"listen"`
+	Path          string           `toml:"path"`
+	ExpireSeconds int              `toml:"expire_seconds"`
+	Rules         []PrometheusRule `toml:"rule"`
+	RouteConfig
+}
+
+// PrometheusRule convert names matched to metric name and labels
+type PrometheusRule struct {
+	Match  string            `toml:"match"`
+	Name   string            `toml:"name"`
+	Labels map[string]string `toml:"labels"`
+}
+
+type promSeries struct {
+	name    string
+	typ     string
+	labels  string
+	value   float64
+	updated time.Time
+}
+
+func NewPrometheus(conf PrometheusConfig) *Prometheus {
+	if conf.Listen == "" {
+		conf.Listen = DefaultPrometheusListen
+	}
+	if conf.Path == "" {
+		conf.Path = DefaultPrometheusPath
+	}
+	if conf.ExpireSeconds <= 0 {
+		conf.ExpireSeconds = DefaultPrometheusExpireSeconds
+	}
+	return &Prometheus{
+		conf:   conf,
+		series: make(map[string]*promSeries),
+	}
+}
+
+// Prometheus keep the latest value of points and serve them
+type Prometheus struct {
+	sync.Mutex
+	conf   PrometheusConfig
+	series map[string]*promSeries
+	server *http.Server
+}
+
+func (p *Prometheus) Name() string {
+	return "prometheus:" + p.conf.Listen
+}
+
+func (p *Prometheus) Start() error {
+	ln, err := net.Listen("tcp", p.conf.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(p.conf.Path, p)
+	p.server = &http.Server{Handler: mux}
+	go p.server.Serve(ln)
+	return nil
+}
+
+func (p *Prometheus) Stop() {
+	if p.server != nil {
+		p.server.Close()
+	}
+}
+
+func (p *Prometheus) Write(points []*Point) error {
+	now := time.Now()
+	p.Lock()
+	defer p.Unlock()
+	for _, pt := range points {
+		name, labels := p.convert(pt)
+		typ := "gauge"
+		switch {
+		case pt.Kind == KindCounter && pt.Field == "rate":
+			continue
+		case pt.Kind == KindCounter && pt.Field == "count":
+			name += "_total"
+			typ = "counter"
+		case pt.Kind == KindGauge && pt.Field == "value":
+		case pt.Field != "":
+			name += "_" + promName(pt.Field)
+		}
+		lbs := promLabels(labels)
+		k := name + lbs
+		s, ok := p.series[k]
+		if !ok {
+			s = &promSeries{name: name, typ: typ, labels: lbs}
+			p.series[k] = s
+		}
+		if typ == "counter" {
+			s.value += pt.Value
+		} else {
+			s.value = pt.Value
+		}
+		s.updated = now
+	}
+	return nil
+}
+
+// convert name and tags of point by rules
+func (p *Prometheus) convert(pt *Point) (string, map[string]string) {
+	labels := make(map[string]string, len(pt.Tags))
+	for k, v := range pt.Tags {
+		labels[promName(k)] = v
+	}
+	for _, r := range p.conf.Rules {
+		captures, ok := promRuleMatch(r.Match, pt.Name)
+		if !ok {
+			continue
+		}
+		for k, v := range r.Labels {
+			labels[promName(k)] = promExpand(v, captures)
+		}
+		return promName(promExpand(r.Name, captures)), labels
+	}
+	return promName(pt.Name), labels
+}
+
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	expire := time.Now().Add(-time.Duration(p.conf.ExpireSeconds) * time.Second)
+	p.Lock()
+	all := make([]*promSeries, 0, len(p.series))
+	for k, s := range p.series {
+		if s.updated.Before(expire) {
+			delete(p.series, k)
+			continue
+		}
+		all = append(all, s)
+	}
+	p.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].labels < all[j].labels
+	})
+
+	var buf bytes.Buffer
+	last := ""
+	for _, s := range all {
+		if s.name != last {
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", s.name, s.typ)
+			last = s.name
+		}
+		buf.WriteString(s.name)
+		buf.WriteString(s.labels)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		buf.WriteByte('\n')
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// promRuleMatch match name by pattern, '*' is one segment, return segments matched by '*'
+func promRuleMatch(pattern, name string) ([]string, bool) {
+	ps := strings.Split(pattern, ".")
+	ns := strings.Split(name, ".")
+	if len(ps) != len(ns) {
+		return nil, false
+	}
+	captures := make([]string, 0)
+	for i, s := range ps {
+		if s == "*" {
+			captures = append(captures, ns[i])
+		} else if s != ns[i] {
+			return nil, false
+		}
+	}
+	return captures, true
+}
+
+// promExpand replace $1, $2 ... with captures, from the last so $1 not replace $10
+func promExpand(s string, captures []string) string {
+	for i := len(captures); i > 0; i-- {
+		s = strings.Replace(s, "$"+strconv.Itoa(i), captures[i-1], -1)
+	}
+	return s
+}
+
+// promName make a valid metric or label name, [a-zA-Z_:][a-zA-Z0-9_:]*
+func promName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':') {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// promLabels format labels sorted by name, like {a="1",b="2"}
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		fmt.Fprintf(&buf, `%s="%s"`, k, v)
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
@@ -0,0 +1,257 @@
+package output
+
+/*
+ push points to a central ingest server over https, authenticated by license_key of host.
+ a batch is POSTed as gzipped json:
+
+	POST /ingest HTTP/1.1
+	Content-Type: application/json
+	Content-Encoding: gzip
+	X-License-Key: <license_key>
+
+	{"agent":"v-collect-agent","host_group":"prod","host_sign":"server-01","points":[
+	  {"name":"system.disk.total","field":"value","kind":"gauge","tags":{"path":"/",...},"value":5.2e+10,"timestamp":1500000000}
+	]}
+
+ responses:
+
+	2xx          accepted
+	401          license key rejected, batch is dropped
+	429, 5xx     retried with backoff, Retry-After of server is respected
+	other 4xx    batch is dropped
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPushHeader     = "X-License-Key"
+	DefaultPushBatchSize  = 1000
+	DefaultPushTimeoutSec = 10
+	DefaultPushMaxRetries = 3
+	// max seconds to wait before a retry, also for Retry-After
+	DefaultPushMaxBackoffSec = 60
+)
+
+type PushConfig struct {
+	Enable bool   `toml:"enable"`
+	Url    string `toml:"url"`
+	// header carrying license_key of host
+	Header             string `toml:"header"`
+	BatchSize          int    `toml:"batch_size"`
+	TimeoutSec         int    `toml:"timeout_sec"`
+	MaxRetries         int    `toml:"max_retries"`
+	MaxBackoffSec      int    `toml:"max_backoff_sec"`
+	TLSCA              string `toml:"tls_ca"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	RouteConfig
+}
+
+type pushBatch struct {
+	Agent     string       `json:"agent"`
+	HostGroup string       `json:"host_group"`
+	HostSign  string       `json:"host_sign"`
+	Points    []*pushPoint `json:"points"`
+}
+
+type pushPoint struct {
+	Name      string            `json:"name"`
+	Field     string            `json:"field,omitempty"`
+	Kind      string            `json:"kind,omitempty"`
+	Tags      map[string]string `json:"tags"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+// pushError is an error response of ingest server
+type pushError struct {
+	status     int
+	retryAfter time.Duration
+	msg        string
+}
+
+func (e *pushError) Error() string {
+	return fmt.Sprintf("ingest response %d: %s", e.status, e.msg)
+}
+
+func (e *pushError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// Permanent is true if the batch is rejected, it won't be accepted next time
+func (e *pushError) Permanent() bool {
+	return !e.retryable()
+}
+
+// NewPush return error if tls_ca can't be loaded
+func NewPush(conf PushConfig, agent, hostGroup, hostSign, licenseKey string) (*Push, error) {
+	if conf.Header == "" {
+		conf.Header = DefaultPushHeader
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultPushBatchSize
+	}
+	if conf.TimeoutSec <= 0 {
+		conf.TimeoutSec = DefaultPushTimeoutSec
+	}
+	if conf.MaxRetries < 0 {
+		conf.MaxRetries = 0
+	} else if conf.MaxRetries == 0 {
+		conf.MaxRetries = DefaultPushMaxRetries
+	}
+	if conf.MaxBackoffSec <= 0 {
+		conf.MaxBackoffSec = DefaultPushMaxBackoffSec
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	if conf.TLSCA != "" {
+		pem, err := ioutil.ReadFile(conf.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", conf.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &Push{
+		conf:       conf,
+		agent:      agent,
+		hostGroup:  hostGroup,
+		hostSign:   hostSign,
+		licenseKey: licenseKey,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   time.Duration(conf.TimeoutSec) * time.Second,
+		},
+	}, nil
+}
+
+// Push post points to ingest server
+type Push struct {
+	conf       PushConfig
+	agent      string
+	hostGroup  string
+	hostSign   string
+	licenseKey string
+	client     *http.Client
+}
+
+func (p *Push) Name() string {
+	return "push:" + p.conf.Url
+}
+
+func (p *Push) Write(points []*Point) error {
+	return writeParts(points, splitPoints(points, p.conf.BatchSize), p.writeBatch)
+}
+
+func (p *Push) writeBatch(points []*Point) error {
+	batch := &pushBatch{
+		Agent:     p.agent,
+		HostGroup: p.hostGroup,
+		HostSign:  p.hostSign,
+		Points:    make([]*pushPoint, 0, len(points)),
+	}
+	for _, pt := range points {
+		batch.Points = append(batch.Points, &pushPoint{
+			Name:      pt.Name,
+			Field:     pt.Field,
+			Kind:      pt.Kind,
+			Tags:      pt.Tags,
+			Value:     pt.Value,
+			Timestamp: pt.Timestamp,
+		})
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(batch); err != nil {
+		return permanentError{err}
+	}
+	gz.Close()
+
+	maxBackoff := time.Duration(p.conf.MaxBackoffSec) * time.Second
+	backoff := time.Second
+	for try := 0; ; try++ {
+		err := p.post(body.Bytes())
+		if err == nil {
+			return nil
+		}
+		wait := backoff
+		if e, ok := err.(*pushError); ok {
+			if e.status == http.StatusUnauthorized {
+				return permanentError{fmt.Errorf("license key rejected by ingest server: %s", e.msg)}
+			}
+			if !e.retryable() {
+				return err
+			}
+			if e.retryAfter > 0 {
+				wait = e.retryAfter
+			}
+		}
+		if try >= p.conf.MaxRetries {
+			return err
+		}
+		if wait > maxBackoff {
+			return fmt.Errorf("%s, retry after %s exceeds max backoff", err, wait)
+		}
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (p *Push) post(body []byte) error {
+	req, err := http.NewRequest("POST", p.conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(p.conf.Header, p.licenseKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	return &pushError{
+		status:     resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		msg:        strings.TrimSpace(string(msg)),
+	}
+}
+
+// parseRetryAfter parse seconds or http date, 0 if absent or invalid
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
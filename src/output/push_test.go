@@ -0,0 +1,174 @@
+package output
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.v); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.v, got, tt.want)
+		}
+	}
+
+	// an http date in the future
+	v := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(v); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("%q: got %s, want about 1m", v, got)
+	}
+}
+
+// pushResponse is a response of pushServer, retryAfter is sent if not empty
+type pushResponse struct {
+	code       int
+	retryAfter string
+}
+
+// pushServer answer requests by responses in order, the last one is repeated
+type pushServer struct {
+	sync.Mutex
+	responses []pushResponse
+	batches   []*pushBatch
+	keys      []string
+	times     []time.Time
+}
+
+func (s *pushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batch := &pushBatch{}
+	if err := json.NewDecoder(gz).Decode(batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.batches = append(s.batches, batch)
+	s.keys = append(s.keys, r.Header.Get("X-License-Key"))
+	s.times = append(s.times, time.Now())
+	resp := s.responses[0]
+	if len(s.responses) > 1 {
+		s.responses = s.responses[1:]
+	}
+	if resp.retryAfter != "" {
+		w.Header().Set("Retry-After", resp.retryAfter)
+	}
+	w.WriteHeader(resp.code)
+}
+
+func TestPushWrite(t *testing.T) {
+	points := []*Point{
+		{Name: "system.disk.total", Field: "value", Kind: KindGauge, Tags: map[string]string{"path": "/"}, Value: 52000000000, Timestamp: 100},
+		{Name: "nginx.requests", Field: "count", Kind: KindCounter, Value: 120, Timestamp: 100},
+	}
+	tests := []struct {
+		name      string
+		conf      PushConfig
+		responses []pushResponse
+		wantErr   bool
+		permanent bool
+		requests  int
+		// min seconds between the first and the last request
+		minWait time.Duration
+	}{
+		{
+			name:      "accepted",
+			responses: []pushResponse{{code: 202}},
+			requests:  1,
+		},
+		{
+			name:      "401 dropped",
+			responses: []pushResponse{{code: 401}},
+			wantErr:   true,
+			permanent: true,
+			requests:  1,
+		},
+		{
+			name:      "400 dropped",
+			responses: []pushResponse{{code: 400}},
+			wantErr:   true,
+			permanent: true,
+			requests:  1,
+		},
+		{
+			name:      "429 retried after Retry-After",
+			conf:      PushConfig{MaxRetries: 1},
+			responses: []pushResponse{{code: 429, retryAfter: "1"}, {code: 200}},
+			requests:  2,
+			minWait:   time.Second,
+		},
+		{
+			name:      "503 retried",
+			conf:      PushConfig{MaxRetries: 1},
+			responses: []pushResponse{{code: 503}, {code: 200}},
+			requests:  2,
+			minWait:   time.Second,
+		},
+		{
+			name:      "500 retries exhausted",
+			conf:      PushConfig{MaxRetries: -1},
+			responses: []pushResponse{{code: 500}},
+			wantErr:   true,
+			requests:  1,
+		},
+		{
+			name:      "Retry-After exceeds max backoff",
+			conf:      PushConfig{MaxBackoffSec: 1},
+			responses: []pushResponse{{code: 429, retryAfter: "120"}},
+			wantErr:   true,
+			requests:  1,
+		},
+	}
+	for _, tt := range tests {
+		s := &pushServer{responses: tt.responses}
+		ts := httptest.NewServer(s)
+		tt.conf.Url = ts.URL
+		p, err := NewPush(tt.conf, "v-collect-agent", "prod", "server-01", "key-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Write(points)
+		ts.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if err != nil && isPermanent(err) != tt.permanent {
+			t.Errorf("%s: got permanent %v, want %v", tt.name, isPermanent(err), tt.permanent)
+		}
+		if len(s.batches) != tt.requests {
+			t.Errorf("%s: got %d requests, want %d", tt.name, len(s.batches), tt.requests)
+			continue
+		}
+		if d := s.times[len(s.times)-1].Sub(s.times[0]); d < tt.minWait {
+			t.Errorf("%s: retried after %s, want %s", tt.name, d, tt.minWait)
+		}
+		b := s.batches[0]
+		if b.Agent != "v-collect-agent" || b.HostGroup != "prod" || b.HostSign != "server-01" || len(b.Points) != 2 {
+			t.Errorf("%s: got batch %+v", tt.name, b)
+		}
+		if s.keys[0] != "key-1" {
+			t.Errorf("%s: got license key %q", tt.name, s.keys[0])
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package output
+
+/*
+ spool batches failed to write on disk, and replay them in order once the output recovers.
+
+ each output has a directory in spool dir, a batch is a json file named by time it's
+ spooled, so files are replayed by name order:
+
+	/var/lib/v-collect/spool/graphite_2003/1500000000000000000.json
+
+ while an output has batches spooled, new batches are spooled too, so points are never
+ written out of order. the oldest batches are dropped if spool is larger than max_bytes
+ or older than max_age_sec.
+
+ self metrics of spool, key like: agent.spool.graphite_2003.depth
+
+	depth             batches spooled
+	bytes             bytes of files spooled
+	spooled           batches spooled, counter
+	replayed          batches replayed, counter
+	replayed_points   points replayed, counter
+	dropped           batches dropped by max_bytes, max_age_sec, broken files or rejected
+	                  by the output when replayed, counter
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/coder-van/v-stats/metrics"
+)
+
+const (
+	DefaultSpoolDir          = "/var/lib/v-collect/spool"
+	DefaultSpoolMaxBytes     = 100 * 1024 * 1024
+	DefaultSpoolMaxAgeSec    = 24 * 3600
+	DefaultSpoolReplayPerSec = 5
+
+	// seconds to wait before next replay if nothing replayed
+	FailedReplayWaitSec = 5
+
+	spoolFileExt = ".json"
+)
+
+type SpoolConfig struct {
+	Enable    bool   `toml:"enable"`
+	Dir       string `toml:"dir"`
+	MaxBytes  int64  `toml:"max_bytes"`
+	MaxAgeSec int    `toml:"max_age_sec"`
+	// batches replayed per second, not to flood an output just recovered
+	ReplayPerSec int `toml:"replay_per_sec"`
+}
+
+func (c *SpoolConfig) setDefault() {
+	if c.Dir == "" {
+		c.Dir = DefaultSpoolDir
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultSpoolMaxBytes
+	}
+	if c.MaxAgeSec <= 0 {
+		c.MaxAgeSec = DefaultSpoolMaxAgeSec
+	}
+	if c.ReplayPerSec <= 0 {
+		c.ReplayPerSec = DefaultSpoolReplayPerSec
+	}
+}
+
+type spoolFile struct {
+	name    string
+	size    int64
+	spooled time.Time
+}
+
+// spool is a directory of batch files, not safe for concurrent use
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	files    []*spoolFile
+	bytes    int64
+}
+
+// openSpool create dir if not exist and load files spooled before restart
+func openSpool(dir string, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, maxBytes: maxBytes, maxAge: maxAge}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, spoolFileExt) {
+			continue
+		}
+		ns, err := strconv.ParseInt(strings.TrimSuffix(name, spoolFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, &spoolFile{name: name, size: info.Size(), spooled: time.Unix(0, ns)})
+		s.bytes += info.Size()
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+	return s, nil
+}
+
+func (s *spool) push(points []*Point) error {
+	b, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	// names must be unique and increasing
+	if n := len(s.files); n > 0 && !now.After(s.files[n-1].spooled) {
+		now = s.files[n-1].spooled.Add(time.Nanosecond)
+	}
+	f := &spoolFile{
+		name:    strconv.FormatInt(now.UnixNano(), 10) + spoolFileExt,
+		size:    int64(len(b)),
+		spooled: now,
+	}
+	fp := filepath.Join(s.dir, f.name)
+	if err := ioutil.WriteFile(fp+".tmp", b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(fp+".tmp", fp); err != nil {
+		return err
+	}
+	s.files = append(s.files, f)
+	s.bytes += f.size
+	return nil
+}
+
+// replace rewrite the oldest batch by points, for the rest of a batch partially replayed
+func (s *spool) replace(points []*Point) error {
+	b, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+	f := s.files[0]
+	fp := filepath.Join(s.dir, f.name)
+	if err := ioutil.WriteFile(fp+".tmp", b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(fp+".tmp", fp); err != nil {
+		return err
+	}
+	s.bytes += int64(len(b)) - f.size
+	f.size = int64(len(b))
+	return nil
+}
+
+// peek read the oldest batch
+func (s *spool) peek() ([]*Point, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, s.files[0].name))
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*Point, 0)
+	if err := json.Unmarshal(b, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// pop remove the oldest batch
+func (s *spool) pop() {
+	f := s.files[0]
+	os.Remove(filepath.Join(s.dir, f.name))
+	s.files = s.files[1:]
+	s.bytes -= f.size
+}
+
+// trim drop the oldest batches beyond size and age cap, return count dropped
+func (s *spool) trim() int {
+	dropped := 0
+	expire := time.Now().Add(-s.maxAge)
+	for len(s.files) > 0 && (s.bytes > s.maxBytes || s.files[0].spooled.Before(expire)) {
+		s.pop()
+		dropped++
+	}
+	return dropped
+}
+
+func (s *spool) len() int {
+	return len(s.files)
+}
+
+func newSpooledOutput(o Output, conf SpoolConfig, registry metrics.Registry) (*spooledOutput, error) {
+	name := util.SanitizeKey(o.Name())
+	sp, err := openSpool(filepath.Join(conf.Dir, name), conf.MaxBytes, time.Duration(conf.MaxAgeSec)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	so := &spooledOutput{
+		Output:   o,
+		BaseStat: metrics.NewBaseStat("agent.spool", registry),
+		key:      name,
+		spool:    sp,
+		interval: time.Second / time.Duration(conf.ReplayPerSec),
+		exit:     make(chan bool),
+	}
+	so.updateStat()
+	return so, nil
+}
+
+// spooledOutput spool batches failed to write by the output and replay them
+type spooledOutput struct {
+	Output
+	*metrics.BaseStat
+	sync.Mutex
+	key      string
+	spool    *spool
+	interval time.Duration
+	exit     chan bool
+}
+
+func (so *spooledOutput) Write(points []*Point) error {
+	so.Lock()
+	defer so.Unlock()
+	if so.spool.len() > 0 {
+		// keep order, replay will write them after batches spooled before
+		return so.push(points)
+	}
+	err := so.Output.Write(points)
+	if isPermanent(err) {
+		// rejected batches will never be accepted, they'd block the spool if spooled
+		return err
+	}
+	if err != nil {
+		// points written before a partial failure are not spooled, or they are duplicated
+		if e := so.push(unsent(points, err)); e != nil {
+			return fmt.Errorf("%s, and spool failed: %s", err, e)
+		}
+		return fmt.Errorf("%s, batch is spooled", err)
+	}
+	return nil
+}
+
+func (so *spooledOutput) push(points []*Point) error {
+	defer so.updateStat()
+	if err := so.spool.push(points); err != nil {
+		return err
+	}
+	so.CounterInc(so.key+".spooled", 1)
+	if n := so.spool.trim(); n > 0 {
+		so.CounterInc(so.key+".dropped", n)
+	}
+	return nil
+}
+
+// replayOne write the oldest batch, return false if nothing to replay or write failed
+func (so *spooledOutput) replayOne() bool {
+	so.Lock()
+	defer so.Unlock()
+	defer so.updateStat()
+	if n := so.spool.trim(); n > 0 {
+		so.CounterInc(so.key+".dropped", n)
+	}
+	if so.spool.len() == 0 {
+		return false
+	}
+	points, err := so.spool.peek()
+	if err != nil {
+		// broken file will never be replayed
+		so.OnErr("error_spool_read", err)
+		so.spool.pop()
+		so.CounterInc(so.key+".dropped", 1)
+		return true
+	}
+	if err := so.Output.Write(points); err != nil {
+		if isPermanent(err) {
+			so.OnErr("error_replay_rejected", err)
+			so.spool.pop()
+			so.CounterInc(so.key+".dropped", 1)
+			return true
+		}
+		if e, ok := err.(*PartialError); ok {
+			if err := so.spool.replace(e.Unsent); err != nil {
+				so.OnErr("error_spool_write", err)
+			}
+		}
+		return false
+	}
+	so.spool.pop()
+	so.CounterInc(so.key+".replayed", 1)
+	so.CounterInc(so.key+".replayed_points", len(points))
+	return true
+}
+
+func (so *spooledOutput) updateStat() {
+	so.GaugeUpdate(so.key+".depth", so.spool.len())
+	so.GaugeUpdate(so.key+".bytes", so.spool.bytes)
+}
+
+func (so *spooledOutput) run(shutdown chan bool) {
+	defer close(so.exit)
+	ticker := time.NewTicker(so.interval)
+	defer ticker.Stop()
+	// wait a while if replay failed or spool is empty, the output is probably still down
+	var wait time.Time
+	for {
+		select {
+		case <-shutdown:
+			return
+		case now := <-ticker.C:
+			if now.Before(wait) {
+				continue
+			}
+			if !so.replayOne() {
+				wait = now.Add(time.Duration(FailedReplayWaitSec) * time.Second)
+			}
+		}
+	}
+}
+
+func (so *spooledOutput) Start() error {
+	go so.run(so.exit)
+	if s, ok := so.Output.(Service); ok {
+		return s.Start()
+	}
+	return nil
+}
+
+func (so *spooledOutput) Stop() {
+	so.exit <- true
+	<-so.exit
+	if s, ok := so.Output.(Service); ok {
+		s.Stop()
+	}
+}
@@ -0,0 +1,187 @@
+package output
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+func testPoints(names ...string) []*Point {
+	points := make([]*Point, 0, len(names))
+	for _, name := range names {
+		points = append(points, &Point{Name: name, Field: "value", Value: 1, Timestamp: 100})
+	}
+	return points
+}
+
+func pointNames(points []*Point) []string {
+	names := make([]string, 0, len(points))
+	for _, p := range points {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// popAll peek and pop batches in order, return names of their points
+func popAll(t *testing.T, s *spool) [][]string {
+	batches := make([][]string, 0)
+	for s.len() > 0 {
+		points, err := s.peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, pointNames(points))
+		s.pop()
+	}
+	return batches
+}
+
+func TestSpoolOrder(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pushed in the same nanosecond or not, names are increasing
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := s.push(testPoints(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a file not spooled is ignored
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+
+	// reopened after restart
+	s, err = openSpool(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, f := range s.files {
+		size += f.size
+	}
+	if s.bytes != size {
+		t.Errorf("got bytes %d, want %d", s.bytes, size)
+	}
+	want := [][]string{{"a"}, {"b"}, {"c"}, {"d"}}
+	if got := popAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if s.bytes != 0 {
+		t.Errorf("got bytes %d after pop, want 0", s.bytes)
+	}
+}
+
+func TestSpoolTrim(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := s.push(testPoints(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.trim(); n != 0 {
+		t.Errorf("got %d dropped under caps, want 0", n)
+	}
+
+	// by bytes, two batches fit
+	s.maxBytes = s.bytes - 1
+	if n := s.trim(); n != 1 {
+		t.Errorf("got %d dropped by bytes, want 1", n)
+	}
+
+	// by age, a batch spooled two hours ago before restart
+	old := strconv.FormatInt(time.Now().Add(-2*time.Hour).UnixNano(), 10) + spoolFileExt
+	if err := os.WriteFile(filepath.Join(dir, old), []byte(`[{"Name":"old"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err = openSpool(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s.trim(); n != 1 {
+		t.Errorf("got %d dropped by age, want 1", n)
+	}
+	want := [][]string{{"b"}, {"c"}}
+	if got := popAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// fakeOutput return errors in order, nil once they are used up
+type fakeOutput struct {
+	errs    []error
+	written [][]string
+}
+
+func (o *fakeOutput) Name() string {
+	return "fake"
+}
+
+func (o *fakeOutput) Write(points []*Point) error {
+	if len(o.errs) > 0 {
+		err := o.errs[0]
+		o.errs = o.errs[1:]
+		if e, ok := err.(*PartialError); ok {
+			// points before unsent are written
+			o.written = append(o.written, pointNames(points[:len(points)-len(e.Unsent)]))
+		}
+		return err
+	}
+	o.written = append(o.written, pointNames(points))
+	return nil
+}
+
+func TestSpooledOutputReplay(t *testing.T) {
+	down := errors.New("connection refused")
+	out := &fakeOutput{}
+	so, err := newSpooledOutput(out, SpoolConfig{Dir: t.TempDir(), MaxBytes: 1 << 20, MaxAgeSec: 3600, ReplayPerSec: 1}, metrics.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := testPoints("a", "b", "c")
+	out.errs = []error{&PartialError{Err: down, Unsent: first[1:]}}
+	if err := so.Write(first); err == nil {
+		t.Error("got no error of partial write")
+	}
+	// spooled while batches are waiting, though the output is up again
+	if err := so.Write(testPoints("d")); err != nil {
+		t.Error(err)
+	}
+	if so.spool.len() != 2 {
+		t.Fatalf("got %d batches spooled, want 2", so.spool.len())
+	}
+
+	// the first replay is written partially, the rest replace the batch
+	out.errs = []error{&PartialError{Err: down, Unsent: testPoints("c")}}
+	if so.replayOne() {
+		t.Error("got replayed, want failed")
+	}
+	if so.spool.len() != 2 {
+		t.Fatalf("got %d batches spooled after partial replay, want 2", so.spool.len())
+	}
+	for so.replayOne() {
+	}
+
+	// rejected when replayed is dropped
+	out.errs = []error{down, permanentError{errors.New("bad request")}}
+	so.Write(testPoints("e"))
+	if !so.replayOne() || so.spool.len() != 0 {
+		t.Errorf("got %d batches spooled after rejected, want 0", so.spool.len())
+	}
+
+	want := [][]string{{"a"}, {"b"}, {"c"}, {"d"}}
+	if !reflect.DeepEqual(out.written, want) {
+		t.Errorf("got written %v, want %v", out.written, want)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+/*
+ register host to get host_sign 注册主机获取主机标识
+
+ if host_sign is not set in config, it's read from state file, if state file not exist,
+ inventory of host is POSTed to register_url with license_key:
+
+	POST /register
+	{"license_key":"...","host_id":"8f4e...","hostname":"web-01","ips":["10.0.0.5"],
+	 "os":"linux","platform":"ubuntu","platform_version":"16.04","kernel_version":"4.4.0",
+	 "arch":"amd64","cpu_model":"Intel(R) Xeon(R) ...","cpu_cores":8,"mem_total":16777216000,
+	 "agent":"v-collect-agent"}
+
+	200 {"host_sign":"server-01","host_group":"prod"}
+
+ host_id is stable on a machine, so a reinstalled agent get the same sign, host_group of
+ response is used only if not set in config. the response is saved to state file.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/coder-van/v-collect/src/util"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/mem"
+)
+
+const (
+	DefaultStateFile = "/var/lib/v-collect/state.json"
+
+	RegisterTimeoutSec = 10
+	RegisterRetries    = 5
+)
+
+// hostState is saved in state file
+type hostState struct {
+	HostSign     string `json:"host_sign"`
+	HostGroup    string `json:"host_group,omitempty"`
+	RegisteredAt int64  `json:"registered_at"`
+}
+
+type registerRequest struct {
+	LicenseKey      string   `json:"license_key"`
+	HostID          string   `json:"host_id"`
+	Hostname        string   `json:"hostname"`
+	IPs             []string `json:"ips"`
+	OS              string   `json:"os"`
+	Platform        string   `json:"platform"`
+	PlatformVersion string   `json:"platform_version"`
+	KernelVersion   string   `json:"kernel_version"`
+	Arch            string   `json:"arch"`
+	CPUModel        string   `json:"cpu_model"`
+	CPUCores        int      `json:"cpu_cores"`
+	MemTotal        uint64   `json:"mem_total"`
+	Agent           string   `json:"agent"`
+}
+
+type registerResponse struct {
+	HostSign  string `json:"host_sign"`
+	HostGroup string `json:"host_group"`
+}
+
+// loadHostIdentity set host_sign and host_group of config by state file or registration
+func loadHostIdentity(c *Config) error {
+	hc := &c.HostConfig
+	if hc.HostSign != "" {
+		return nil
+	}
+	if hc.StateFile == "" {
+		hc.StateFile = DefaultStateFile
+	}
+
+	state, err := readHostState(hc.StateFile)
+	if err != nil {
+		return err
+	}
+	if state == nil && hc.RegisterUrl != "" {
+		resp, err := register(c)
+		if err != nil {
+			return err
+		}
+		state = &hostState{
+			HostSign:     util.SanitizeKey(resp.HostSign),
+			HostGroup:    util.SanitizeKey(resp.HostGroup),
+			RegisteredAt: time.Now().Unix(),
+		}
+		if err := writeHostState(hc.StateFile, state); err != nil {
+			return err
+		}
+		fmt.Printf("--- Registered as %s ---\n", state.HostSign)
+	}
+	if state != nil {
+		hc.HostSign = state.HostSign
+		if hc.HostGroup == "" {
+			hc.HostGroup = state.HostGroup
+		}
+	}
+	return nil
+}
+
+// readHostState return nil if state file not exist
+func readHostState(fp string) (*hostState, error) {
+	b, err := ioutil.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &hostState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("bad state file %s: %s", fp, err)
+	}
+	if state.HostSign == "" {
+		return nil, nil
+	}
+	return state, nil
+}
+
+// writeHostState write to a temp file then rename, so state file is never half written
+func writeHostState(fp string, state *hostState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	tmp := fp + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp)
+}
+
+// register retry with backoff, the agent can't work without a sign
+func register(c *Config) (*registerResponse, error) {
+	body, err := json.Marshal(newRegisterRequest(c))
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: RegisterTimeoutSec * time.Second}
+	backoff := time.Second
+	for try := 1; ; try++ {
+		resp, err := postRegister(client, c.HostConfig.RegisterUrl, body)
+		if err == nil {
+			return resp, nil
+		}
+		if try >= RegisterRetries {
+			return nil, err
+		}
+		fmt.Printf("Register failed: %s, retry in %s\n", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postRegister(client *http.Client, url string, body []byte) (*registerResponse, error) {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("register response %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	r := &registerResponse{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("bad register response: %s", err)
+	}
+	if util.SanitizeKey(r.HostSign) == "" {
+		return nil, fmt.Errorf("no host_sign in register response")
+	}
+	return r, nil
+}
+
+// newRegisterRequest collect inventory of host, items failed to get are left empty
+func newRegisterRequest(c *Config) *registerRequest {
+	r := &registerRequest{
+		LicenseKey: c.HostConfig.LicenseKey,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUCores:   runtime.NumCPU(),
+		Agent:      c.AgentDefaultName,
+		IPs:        hostIPs(),
+	}
+	r.Hostname, _ = os.Hostname()
+	if info, err := host.Info(); err == nil {
+		r.HostID = info.HostID
+		r.Platform = info.Platform
+		r.PlatformVersion = info.PlatformVersion
+		r.KernelVersion = info.KernelVersion
+	}
+	if cpus, err := cpu.Info(); err == nil && len(cpus) > 0 {
+		r.CPUModel = cpus[0].ModelName
+	}
+	if vm, err := mem.VirtualMemory(); err == nil {
+		r.MemTotal = vm.Total
+	}
+	return r
+}
+
+// hostIPs return addresses of interfaces up, loopback excluded
+func hostIPs() []string {
+	ips := make([]string, 0)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ips
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipnet.IP.String())
+			}
+		}
+	}
+	return ips
+}
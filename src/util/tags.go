@@ -0,0 +1,114 @@
+package util
+
+/*
+ tags configured in [tags] and tags of collectors are merged into the tags segment of
+ metric names registered, the segment made by metrics.MakeMetric:
+
+	system.cpu.usage             ->  system.cpu.usage.dc=sh1,env=prod
+	system.disk.total.path=/     ->  system.disk.total.dc=sh1,env=prod,path=/
+
+ so they are in the path for graphite, and parsed as tags of points for other outputs.
+ tags of a metric win over tags of its collector, which win over global tags.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coder-van/v-stats/metrics"
+)
+
+// tags set from host config, not configurable by [tags]
+var reservedTags = []string{"host_group", "host_sign"}
+
+// CheckTags return error if a tag can't be encoded in a metric key
+func CheckTags(tags map[string]string) error {
+	for k, v := range tags {
+		for _, r := range reservedTags {
+			if k == r {
+				return fmt.Errorf("tag %s is reserved, set it in [host]", k)
+			}
+		}
+		if k == "" || strings.ContainsAny(k, ".,=; ") {
+			return fmt.Errorf("bad tag name %q", k)
+		}
+		if v == "" || strings.ContainsAny(v, ",=; ") {
+			return fmt.Errorf("bad value %q of tag %s", v, k)
+		}
+	}
+	return nil
+}
+
+// MergeTags merge tags into the tags segment of name, tags already in name win
+func MergeTags(name string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	// MakeMetric with no tags leave a trailing '.'
+	name = strings.TrimSuffix(name, ".")
+	merged := make(map[string]string, len(tags))
+	for k, v := range tags {
+		merged[k] = v
+	}
+	// values of tags may contain '.', tags segment is from the first segment with '='
+	segs := strings.Split(name, ".")
+	for j, seg := range segs {
+		if strings.Contains(seg, "=") {
+			name = strings.Join(segs[:j], ".")
+			for _, kv := range strings.Split(strings.Join(segs[j:], "."), ",") {
+				if t := strings.SplitN(kv, "=", 2); len(t) == 2 && t[0] != "" {
+					merged[t[0]] = t[1]
+				}
+			}
+			break
+		}
+	}
+	return metrics.MakeMetric(name, merged)
+}
+
+// NewTaggedRegistry return a registry merging tags into names of metrics, parent is
+// returned if no tags. Each walks all metrics of parent, and a PrefixedRegistry can't
+// be made on it, wrap a PrefixedRegistry with it instead.
+func NewTaggedRegistry(parent metrics.Registry, tags map[string]string) metrics.Registry {
+	if len(tags) == 0 {
+		return parent
+	}
+	return &taggedRegistry{parent: parent, tags: tags}
+}
+
+type taggedRegistry struct {
+	parent metrics.Registry
+	tags   map[string]string
+}
+
+func (r *taggedRegistry) Each(fn func(string, interface{})) {
+	r.parent.Each(fn)
+}
+
+func (r *taggedRegistry) Get(name string) interface{} {
+	return r.parent.Get(MergeTags(name, r.tags))
+}
+
+func (r *taggedRegistry) Len() int {
+	return r.parent.Len()
+}
+
+func (r *taggedRegistry) GetOrRegister(name string, metric interface{}) interface{} {
+	return r.parent.GetOrRegister(MergeTags(name, r.tags), metric)
+}
+
+func (r *taggedRegistry) Register(name string, metric interface{}) error {
+	return r.parent.Register(MergeTags(name, r.tags), metric)
+}
+
+func (r *taggedRegistry) RunHealthChecks() {
+	r.parent.RunHealthChecks()
+}
+
+func (r *taggedRegistry) Unregister(name string) {
+	r.parent.Unregister(MergeTags(name, r.tags))
+}
+
+func (r *taggedRegistry) UnregisterAll() {
+	r.parent.UnregisterAll()
+}
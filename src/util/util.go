@@ -2,6 +2,7 @@ package util
 
 import (
 	"hash/fnv"
+	"strings"
 )
 
 // Contains reports whether an item is within the slice.
@@ -20,3 +21,23 @@ func Hash(s string) uint32 {
 	_, _ = h.Write([]byte(s))
 	return h.Sum32()
 }
+
+// SanitizeKey makes a string safe to use as one segment of a dotted metric key.
+// Dots, spaces and other separators are replaced by '_'.
+func SanitizeKey(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+			b = append(b, c)
+		default:
+			if len(b) > 0 && b[len(b)-1] == '_' {
+				continue
+			}
+			b = append(b, '_')
+		}
+	}
+	return strings.Trim(string(b), "_")
+}